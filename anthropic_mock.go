@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"mock-stream/util"
+)
+
+type anthropicRequest struct {
+	Model    string        `json:"model"`
+	Stream   bool          `json:"stream"`
+	Messages []chatMessage `json:"messages"`
+}
+
+func (r *anthropicRequest) responseModel() string {
+	if r.Model == "" {
+		return "mock-stream"
+	}
+	return r.Model
+}
+
+// anthropicStopReason is "tool_use" when resp calls functions
+func (resp mockResponse) anthropicStopReason() string {
+	if len(resp.ToolCalls) > 0 {
+		return "tool_use"
+	}
+	return "end_turn"
+}
+
+// handleAnthropicMessages mocks the Anthropic Messages API (/v1/messages)
+func handleAnthropicMessages(w http.ResponseWriter, r *http.Request) {
+	var req anthropicRequest
+	body, parseErr := readJSONBody(r, &req)
+	if model := routeParam(r, "model"); model != "" {
+		req.Model = model
+	}
+
+	resp, ok := resolveMock(w, r, &mockRequest{
+		API:      apiAnthropicMessage,
+		Model:    req.Model,
+		Stream:   req.Stream,
+		Messages: req.Messages,
+		Body:     body,
+	}, parseErr)
+	if !ok {
+		return
+	}
+
+	if !req.Stream {
+		writeAnthropicMessage(w, &req, resp)
+		return
+	}
+	streamAnthropicMessage(w, &req, resp)
+}
+
+// writeAnthropicError writes an error in the Anthropic error format
+func writeAnthropicError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(anthropicErrorBody(status, message))
+}
+
+func anthropicErrorBody(status int, message string) map[string]interface{} {
+	var errType string
+	switch status {
+	case http.StatusBadRequest:
+		errType = "invalid_request_error"
+	case http.StatusUnauthorized:
+		errType = "authentication_error"
+	case http.StatusForbidden:
+		errType = "permission_error"
+	case http.StatusNotFound:
+		errType = "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		errType = "request_too_large"
+	case http.StatusTooManyRequests:
+		errType = "rate_limit_error"
+	case http.StatusServiceUnavailable, 529:
+		errType = "overloaded_error"
+	default:
+		errType = "api_error"
+	}
+
+	return map[string]interface{}{
+		"type": "error",
+		"error": map[string]string{
+			"type":    errType,
+			"message": message,
+		},
+	}
+}
+
+func writeAnthropicMessage(w http.ResponseWriter, req *anthropicRequest, resp mockResponse) {
+	if resp.RawMode {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, resp.Thinking+resp.Content)
+		return
+	}
+
+	content := []interface{}{}
+	if resp.Thinking != "" {
+		content = append(content, map[string]string{
+			"type":      "thinking",
+			"thinking":  resp.Thinking,
+			"signature": util.RandomID("", 64),
+		})
+	}
+	if resp.Content != "" {
+		content = append(content, map[string]string{
+			"type": "text",
+			"text": resp.Content,
+		})
+	}
+	for _, call := range resp.ToolCalls {
+		content = append(content, map[string]interface{}{
+			"type":  "tool_use",
+			"id":    util.RandomID("toolu_", 24),
+			"name":  call.Name,
+			"input": call.argumentsObject(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":            util.RandomID("msg_", 24),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         req.responseModel(),
+		"content":       content,
+		"stop_reason":   resp.anthropicStopReason(),
+		"stop_sequence": nil,
+		"usage": map[string]int{
+			"input_tokens":  promptTokens(req.Messages),
+			"output_tokens": resp.completionTokens(),
+		},
+	})
+}
+
+// anthropicStream writes named server-sent events
+type anthropicStream struct {
+	w       http.ResponseWriter
+	rawMode bool
+	index   int // index of the next content block
+}
+
+func (s *anthropicStream) event(name string, data interface{}) {
+	if s.rawMode {
+		return
+	}
+	jsonData, _ := json.Marshal(data)
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, jsonData)
+}
+
+// block streams one content block, sending each chunk as the delta built by delta
+func (s *anthropicStream) block(contentBlock map[string]interface{}, chunks []string, p pace, delta func(chunk string) map[string]string) {
+	index := s.index
+	s.index++
+
+	s.event("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         index,
+		"content_block": contentBlock,
+	})
+	streamChunks(s.w, chunks, p, func(ch string) {
+		if s.rawMode {
+			fmt.Fprintf(s.w, "%s\n", ch)
+			return
+		}
+		s.event("content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": index,
+			"delta": delta(ch),
+		})
+	})
+	if contentBlock["type"] == "thinking" {
+		s.event("content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": index,
+			"delta": map[string]string{"type": "signature_delta", "signature": util.RandomID("", 64)},
+		})
+	}
+	s.event("content_block_stop", map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": index,
+	})
+}
+
+func streamAnthropicMessage(w http.ResponseWriter, req *anthropicRequest, resp mockResponse) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	s := &anthropicStream{w: w, rawMode: resp.RawMode}
+	s.event("message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":            util.RandomID("msg_", 24),
+			"type":          "message",
+			"role":          "assistant",
+			"model":         req.responseModel(),
+			"content":       []interface{}{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage": map[string]int{
+				"input_tokens":  promptTokens(req.Messages),
+				"output_tokens": 1,
+			},
+		},
+	})
+	s.event("ping", map[string]string{"type": "ping"})
+	w.(http.Flusher).Flush()
+
+	if resp.Thinking != "" {
+		s.block(map[string]interface{}{"type": "thinking", "thinking": "", "signature": ""},
+			resp.split(resp.Thinking), resp.thinkingPace(), func(ch string) map[string]string {
+				return map[string]string{"type": "thinking_delta", "thinking": ch}
+			})
+	}
+	if resp.Content != "" {
+		s.block(map[string]interface{}{"type": "text", "text": ""},
+			resp.split(resp.Content), resp.contentPace(), func(ch string) map[string]string {
+				return map[string]string{"type": "text_delta", "text": ch}
+			})
+	}
+	for _, call := range resp.ToolCalls {
+		// The input must be a JSON object, the same as the one of a response that is not streamed
+		input, _ := json.Marshal(call.argumentsObject())
+		s.block(map[string]interface{}{"type": "tool_use", "id": util.RandomID("toolu_", 24), "name": call.Name, "input": map[string]interface{}{}},
+			util.SplitRunes(string(input), toolArgumentsChunkSize), resp.toolCallsPace(), func(ch string) map[string]string {
+				return map[string]string{"type": "input_json_delta", "partial_json": ch}
+			})
+	}
+
+	s.event("message_delta", map[string]interface{}{
+		"type": "message_delta",
+		"delta": map[string]interface{}{
+			"stop_reason":   resp.anthropicStopReason(),
+			"stop_sequence": nil,
+		},
+		"usage": map[string]int{
+			"output_tokens": resp.completionTokens(),
+		},
+	})
+	s.event("message_stop", map[string]string{"type": "message_stop"})
+	w.(http.Flusher).Flush()
+}
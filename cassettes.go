@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"mock-stream/recorder"
+)
+
+// Cassette modes
+const (
+	cassetteRecord = "record" // proxied responses are saved as cassettes
+	cassetteReplay = "replay" // proxied requests with a cassette are answered from it
+)
+
+// cassette is a recorded backend response with the timing of its chunks
+type cassette struct {
+	Request  cassetteRequest `json:"request"`
+	Status   int             `json:"status"`
+	Header   http.Header     `json:"header"`
+	HeaderMs float64         `json:"header_ms"` // when the headers arrived
+	Chunks   []cassetteChunk `json:"chunks"`
+}
+
+type cassetteRequest struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Query  string          `json:"query,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+type cassetteChunk struct {
+	OffsetMs float64 `json:"offset_ms"` // since the request arrived
+	Data     string  `json:"data"`
+}
+
+// requestFingerprint identifies requests that get the same cassette: method, path, the query without
+// the API key, and the JSON body without the ignored top level fields, independent of formatting and
+// key order. The query tells apart the stream formats of Gemini, like alt=sse.
+func requestFingerprint(r *http.Request, body []byte, ignoreFields []string) string {
+	normalized := body
+	var obj map[string]interface{}
+	if json.Unmarshal(body, &obj) == nil {
+		for _, field := range ignoreFields {
+			delete(obj, field)
+		}
+		normalized, _ = json.Marshal(obj)
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.Path)
+	if query := cassetteQuery(r); query != "" {
+		fmt.Fprintf(h, "?%s\n", query)
+	}
+	h.Write(normalized)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// cassetteQuery returns the query of r without the key parameter, in a stable order
+func cassetteQuery(r *http.Request) string {
+	query := r.URL.Query()
+	query.Del("key")
+	return query.Encode()
+}
+
+// readBody returns the body of r and puts it back for the proxy
+func readBody(r *http.Request) []byte {
+	body, _ := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body
+}
+
+func cassettePath(dir, fingerprint string) string {
+	return filepath.Join(dir, fingerprint+".json")
+}
+
+func loadCassette(dir, fingerprint string) (*cassette, error) {
+	data, err := os.ReadFile(cassettePath(dir, fingerprint))
+	if err != nil {
+		return nil, err
+	}
+	var c cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("cassette %s: %w", fingerprint, err)
+	}
+	return &c, nil
+}
+
+func saveCassette(dir, fingerprint string, c *cassette) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cassettePath(dir, fingerprint), data, 0o644)
+}
+
+// cassetteWriter records the backend response with the time of every write
+type cassetteWriter struct {
+	http.ResponseWriter
+	start    time.Time
+	cassette cassette
+}
+
+func newCassetteWriter(w http.ResponseWriter, r *http.Request, body []byte, start time.Time) *cassetteWriter {
+	c := cassette{Request: cassetteRequest{Method: r.Method, Path: r.URL.Path, Query: cassetteQuery(r)}}
+	if json.Valid(body) {
+		c.Request.Body = body
+	}
+	return &cassetteWriter{ResponseWriter: w, start: start, cassette: c}
+}
+
+func (cw *cassetteWriter) since() float64 {
+	return float64(time.Since(cw.start).Microseconds()) / 1000
+}
+
+func (cw *cassetteWriter) WriteHeader(status int) {
+	cw.cassette.Status = status
+	cw.cassette.HeaderMs = cw.since()
+	cw.cassette.Header = cw.Header().Clone()
+	for _, name := range []string{"Date", "Content-Length", "Transfer-Encoding", "Connection"} {
+		cw.cassette.Header.Del(name)
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *cassetteWriter) Write(p []byte) (int, error) {
+	if cw.cassette.Status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	cw.cassette.Chunks = append(cw.cassette.Chunks, cassetteChunk{OffsetMs: cw.since(), Data: string(p)})
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *cassetteWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *cassetteWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// replayCassette sends the recorded response with its original timing, speed 2 replays twice as fast
+func replayCassette(w http.ResponseWriter, c *cassette, speed float64, start time.Time) {
+	if speed <= 0 {
+		speed = 1
+	}
+	waitUntil := func(offsetMs float64) {
+		time.Sleep(time.Until(start.Add(time.Duration(offsetMs / speed * float64(time.Millisecond)))))
+	}
+
+	waitUntil(c.HeaderMs)
+	for name, values := range c.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(c.Status)
+	w.(http.Flusher).Flush()
+	for _, chunk := range c.Chunks {
+		waitUntil(chunk.OffsetMs)
+		io.WriteString(w, chunk.Data)
+		w.(http.Flusher).Flush()
+	}
+}
+
+// handleReplay answers a request of a mocked api from its cassette in replay mode, before any rule
+// decides to mock or proxy it. Without a cassette the request fails, unless Passthrough is set.
+// It reports whether it answered the request.
+func handleReplay(w http.ResponseWriter, r *http.Request, api string) bool {
+	start := time.Now()
+	configMutex.RLock()
+	cassettes, faults := appConfig.Cassettes, appConfig.ProxyFaults
+	configMutex.RUnlock()
+	if cassettes.Mode != cassetteReplay {
+		return false
+	}
+
+	fingerprint := requestFingerprint(r, readBody(r), cassettes.IgnoreFields)
+	c, err := loadCassette(cassettes.Dir, fingerprint)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			if cassettes.Passthrough {
+				return false
+			}
+			err = fmt.Errorf("no cassette %s for %s %s", fingerprint, r.Method, r.URL.Path)
+		}
+		message := "Replay failed: " + err.Error()
+		requestLogger.LogWithRequest(message, r, "")
+		writeAPIError(w, api, http.StatusNotFound, message)
+		return true
+	}
+
+	recorder.MarkProxied(r)
+	if out, ok := proxyFaultWriter(w, r, api, faults, "Replaying cassette "+fingerprint); ok {
+		replayCassette(out, c, cassettes.Speed, start)
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRequestFingerprint(t *testing.T) {
+	type request struct {
+		method, target, body string
+	}
+	tests := []struct {
+		name string
+		a, b request
+		same bool
+	}{
+		{"formatting and key order", request{"POST", "/v1/chat/completions", `{"model":"gpt-4o","stream":true}`},
+			request{"POST", "/v1/chat/completions", "{\n  \"stream\": true,\n  \"model\": \"gpt-4o\"\n}"}, true},
+		{"ignored field", request{"POST", "/v1/chat/completions", `{"model":"gpt-4o","user":"a"}`},
+			request{"POST", "/v1/chat/completions", `{"model":"gpt-4o","user":"b"}`}, true},
+		{"other field", request{"POST", "/v1/chat/completions", `{"model":"gpt-4o"}`},
+			request{"POST", "/v1/chat/completions", `{"model":"gpt-4o-mini"}`}, false},
+		{"method", request{"POST", "/v1/models", ``}, request{"GET", "/v1/models", ``}, false},
+		{"path", request{"POST", "/v1/chat/completions", `{}`}, request{"POST", "/v1/responses", `{}`}, false},
+		{"stream format", request{"POST", "/v1beta/models/gemini-pro:streamGenerateContent?alt=sse", `{}`},
+			request{"POST", "/v1beta/models/gemini-pro:streamGenerateContent", `{}`}, false},
+		{"api key", request{"POST", "/v1beta/models/gemini-pro:streamGenerateContent?alt=sse&key=a", `{}`},
+			request{"POST", "/v1beta/models/gemini-pro:streamGenerateContent?key=b&alt=sse", `{}`}, true},
+		{"query order", request{"GET", "/v1/models?a=1&b=2", ``}, request{"GET", "/v1/models?b=2&a=1", ``}, true},
+		{"body that is not JSON", request{"POST", "/v1/chat/completions", `model=a`},
+			request{"POST", "/v1/chat/completions", `model=b`}, false},
+	}
+	fingerprint := func(req request) string {
+		return requestFingerprint(httptest.NewRequest(req.method, req.target, nil), []byte(req.body), []string{"user"})
+	}
+	for _, tt := range tests {
+		if same := fingerprint(tt.a) == fingerprint(tt.b); same != tt.same {
+			t.Errorf("%s: same fingerprint %v, want %v", tt.name, same, tt.same)
+		}
+	}
+}
+
+func TestCassettes(t *testing.T) {
+	var requests int
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("X-Fail") != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid key"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first alt=" + r.URL.Query().Get("alt") + "\n\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("data: second\n\n"))
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	cfg := testConfig()
+	cfg.BackendURL = backend.URL
+	cfg.Rules = []Rule{{Name: "proxy", Action: actionProxy}}
+	cfg.Cassettes = Cassettes{Mode: cassetteRecord, Dir: dir, Speed: 100, IgnoreFields: []string{"user"}}
+	useConfig(t, cfg)
+
+	const path = "/v1beta/models/gemini-pro:streamGenerateContent"
+	sse := serve("POST", path+"?alt=sse&key=secret", `{"contents":[],"user":"a"}`, nil)
+	array := serve("POST", path, `{"contents":[]}`, nil)
+	serve("POST", path, `{"contents":["unauthorized"]}`, map[string]string{"X-Fail": "1"})
+	if requests != 3 || sse.Body.String() != "data: first alt=sse\n\ndata: second\n\n" {
+		t.Fatalf("recording: %d requests, got %q", requests, sse.Body)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 2 {
+		t.Errorf("%d cassettes recorded, want 2 without the unauthorized response", len(files))
+	}
+
+	configMutex.Lock()
+	appConfig.Cassettes.Mode = cassetteReplay
+	configMutex.Unlock()
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		status int
+		want   string
+	}{
+		{"sse", path + "?key=other&alt=sse", `{"user":"b","contents":[]}`, 200, sse.Body.String()},
+		{"json array", path, `{ "contents": [] }`, 200, array.Body.String()},
+		{"unauthorized was not recorded", path, `{"contents":["unauthorized"]}`, 404, "Replay failed: no cassette"},
+		{"no cassette", path + "?alt=sse", `{"contents":["other"]}`, 404, "Replay failed: no cassette"},
+	}
+	for _, tt := range tests {
+		w := serve("POST", tt.target, tt.body, nil)
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s: got %d %q, want %d with %q", tt.name, w.Code, w.Body, tt.status, tt.want)
+		}
+		if tt.status == 200 && w.Header().Get("Content-Type") != "text/event-stream" {
+			t.Errorf("%s: Content-Type %q not replayed", tt.name, w.Header().Get("Content-Type"))
+		}
+	}
+	if requests != 3 {
+		t.Errorf("replaying reached the backend %d times", requests-3)
+	}
+
+	configMutex.Lock()
+	appConfig.Cassettes.Passthrough = true
+	configMutex.Unlock()
+	if w := serve("POST", path+"?alt=sse", `{"contents":["other"]}`, nil); w.Code != 200 || requests != 4 {
+		t.Errorf("passthrough: got %d %q after %d requests, want the request proxied", w.Code, w.Body, requests)
+	}
+}
@@ -0,0 +1,154 @@
+package chunker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"mock-stream/util"
+)
+
+// Chunking strategies
+const (
+	Line  = "line"  // up to and including each newline
+	Rune  = "rune"  // one character per chunk
+	Word  = "word"  // words with their leading whitespace
+	Fixed = "fixed" // size characters per chunk
+	Regex = "regex" // a chunk ends after every match of the pattern
+	BPE   = "bpe"   // pieces of about the size of the tokens of real models
+)
+
+// Strategies lists the chunking strategies, the first one is the default
+var Strategies = []string{Line, Rune, Word, Fixed, Regex, BPE}
+
+// Chunker splits text into the chunks that are streamed one by one.
+// Chunks never split a multi-byte character and join back to the text.
+type Chunker func(text string) []string
+
+// New returns the chunker of strategy, an empty strategy splits lines.
+// size is used by Fixed, pattern by Regex.
+func New(strategy string, size int, pattern string) (Chunker, error) {
+	switch strategy {
+	case "", Line:
+		return splitLines, nil
+	case Rune:
+		return func(text string) []string { return util.SplitRunes(text, 1) }, nil
+	case Word:
+		return splitWords, nil
+	case Fixed:
+		if size <= 0 {
+			return nil, fmt.Errorf("chunk size must be positive, got %d", size)
+		}
+		return func(text string) []string { return util.SplitRunes(text, size) }, nil
+	case Regex:
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("chunk pattern: %w", err)
+		}
+		return func(text string) []string { return splitAfterMatches(text, re) }, nil
+	case BPE:
+		return splitTokens, nil
+	}
+	return nil, fmt.Errorf("unknown chunking %q", strategy)
+}
+
+func splitLines(text string) []string {
+	return strings.SplitAfter(text, "\n")
+}
+
+// splitWords attaches whitespace to the following word, trailing whitespace to the last one
+func splitWords(text string) []string {
+	var chunks []string
+	start := 0
+	inWord := false
+	for i, r := range text {
+		space := unicode.IsSpace(r)
+		if space && inWord {
+			chunks = append(chunks, text[start:i])
+			start = i
+		}
+		inWord = !space
+	}
+	if start < len(text) {
+		if len(chunks) > 0 && strings.TrimSpace(text[start:]) == "" {
+			chunks[len(chunks)-1] += text[start:]
+		} else {
+			chunks = append(chunks, text[start:])
+		}
+	}
+	return chunks
+}
+
+func splitAfterMatches(text string, re *regexp.Regexp) []string {
+	var chunks []string
+	start := 0
+	for _, match := range re.FindAllStringIndex(text, -1) {
+		if match[1] <= start {
+			continue
+		}
+		chunks = append(chunks, text[start:match[1]])
+		start = match[1]
+	}
+	if start < len(text) {
+		chunks = append(chunks, text[start:])
+	}
+	return chunks
+}
+
+// pretokens splits text like the GPT tokenizers do before merging:
+// contractions, words with a leading space, up to 3 digits, punctuation runs and whitespace.
+var pretokens = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)| ?\pL+| ?\pN{1,3}| ?[^\s\pL\pN]+|\s+`)
+
+// maxTokenLetters is the longest word kept as one token, longer words are split into pieces of tokenLetters
+const (
+	maxTokenLetters = 6
+	tokenLetters    = 4
+)
+
+// splitTokens approximates a BPE tokenizer. Scripts without spaces between words,
+// like Chinese and Japanese, are split per character, as their common characters are about one token.
+func splitTokens(text string) []string {
+	var chunks []string
+	last := 0
+	for _, match := range pretokens.FindAllStringIndex(text, -1) {
+		if match[0] > last {
+			chunks = append(chunks, text[last:match[0]])
+		}
+		chunks = append(chunks, splitWord(text[match[0]:match[1]])...)
+		last = match[1]
+	}
+	if last < len(text) {
+		chunks = append(chunks, text[last:])
+	}
+	return chunks
+}
+
+func splitWord(word string) []string {
+	runes := []rune(word)
+	letters := runes
+	if len(letters) > 0 && letters[0] == ' ' {
+		letters = letters[1:]
+	}
+	if len(letters) == 0 || !unicode.IsLetter(letters[0]) {
+		return []string{word}
+	}
+	if isIdeographic(letters[0]) {
+		return util.SplitRunes(word, 1)
+	}
+	if len(letters) <= maxTokenLetters {
+		return []string{word}
+	}
+	// The leading space stays with the first piece, a single letter left at the end joins the last piece
+	first := len(runes) - len(letters) + tokenLetters
+	pieces := append([]string{string(runes[:first])}, util.SplitRunes(string(runes[first:]), tokenLetters)...)
+	if n := len(pieces); utf8.RuneCountInString(pieces[n-1]) == 1 {
+		pieces = append(pieces[:n-2], pieces[n-2]+pieces[n-1])
+	}
+	return pieces
+}
+
+func isIdeographic(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Thai)
+}
@@ -0,0 +1,94 @@
+package chunker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChunkers(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		size     int
+		pattern  string
+		text     string
+		want     []string
+	}{
+		{"line", Line, 0, "", "one\ntwo\nthree", []string{"one\n", "two\n", "three"}},
+		{"default is line", "", 0, "", "a\nb", []string{"a\n", "b"}},
+		{"rune", Rune, 0, "", "héllo", []string{"h", "é", "l", "l", "o"}},
+		{"word", Word, 0, "", "Hello  big world", []string{"Hello", "  big", " world"}},
+		{"word keeps trailing space", Word, 0, "", " hi there \n", []string{" hi", " there \n"}},
+		{"fixed", Fixed, 3, "", "abcdefgh", []string{"abc", "def", "gh"}},
+		{"fixed counts characters", Fixed, 2, "", "日本語です", []string{"日本", "語で", "す"}},
+		{"regex", Regex, 0, `[.!?]\s*`, "One. Two! Three", []string{"One. ", "Two! ", "Three"}},
+		{"regex without match", Regex, 0, `;`, "no match", []string{"no match"}},
+		{"bpe short words", BPE, 0, "", "I am here.", []string{"I", " am", " here", "."}},
+		{"bpe long word", BPE, 0, "", " tokenization", []string{" toke", "niza", "tion"}},
+		{"bpe single letter tail joins", BPE, 0, "", "abcdefghi", []string{"abcd", "efghi"}},
+		{"bpe contraction and digits", BPE, 0, "", "it's 12345", []string{"it", "'s", " 123", "45"}},
+		{"bpe ideographs", BPE, 0, "", "你好世界", []string{"你", "好", "世", "界"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk, err := New(tt.strategy, tt.size, tt.pattern)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			if got := chunk(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunks of %q = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+// Every strategy must keep characters whole and lose nothing
+func TestChunksJoinBackToText(t *testing.T) {
+	texts := []string{
+		"",
+		"plain ascii text\nwith two lines",
+		"héllo wörld, ça va? ",
+		"日本語のテキストと English mixed 😀🎉 emoji",
+		"  leading and trailing spaces  ",
+		"internationalization\tand\r\nunderstanding 1234567",
+	}
+	for _, strategy := range Strategies {
+		chunk, err := New(strategy, 3, `[,.?]`)
+		if err != nil {
+			t.Fatalf("New(%q): %v", strategy, err)
+		}
+		for _, text := range texts {
+			chunks := chunk(text)
+			if joined := strings.Join(chunks, ""); joined != text {
+				t.Errorf("%s: chunks of %q join to %q", strategy, text, joined)
+			}
+			for _, c := range chunks {
+				if !utf8.ValidString(c) {
+					t.Errorf("%s: chunk %q of %q splits a character", strategy, c, text)
+				}
+			}
+		}
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		size     int
+		pattern  string
+	}{
+		{"unknown strategy", "sentence", 0, ""},
+		{"fixed without size", Fixed, 0, ""},
+		{"invalid pattern", Regex, 0, "("},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.strategy, tt.size, tt.pattern); err == nil {
+				t.Error("New succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"mock-stream/chunker"
+	"mock-stream/latency"
+	"mock-stream/recorder"
+
+	"github.com/BurntSushi/toml"
+	"github.com/fsnotify/fsnotify"
+)
+
+type Config struct {
+	BackendURL        string     `toml:"backend_url"`
+	MockContent       string     `toml:"mock_content"`
+	MockContentRate   int        `toml:"mock_content_rate"`
+	MockThinking      string     `toml:"mock_thinking"`
+	MockThinkingRate  int        `toml:"mock_thinking_rate"`
+	MockToolCalls     []ToolCall `toml:"mock_tool_calls"`
+	MockToolCallsRate int        `toml:"mock_tool_calls_rate"`
+	MockFunctions     string     `toml:"mock_functions"`
+	MockEcho          bool       `toml:"mock_echo"`
+	MockEchoTransform string     `toml:"mock_echo_transform"`
+	MockChunking      string     `toml:"mock_chunking"`
+	MockChunkSize     int        `toml:"mock_chunk_size"`
+	MockChunkPattern  string     `toml:"mock_chunk_pattern"`
+	Running           bool       `toml:"-"`
+	MockEnabled       bool       `toml:"mock_enabled"`
+	RawMode           bool       `toml:"raw_mode"` // return raw line instead of "data: {...}"
+	Port              int        `toml:"port"`
+
+	// Latency models the timing of mocked streams beyond the fixed rates
+	Latency latency.Model `toml:"latency"`
+
+	// Routes maps each mocked api to its path patterns, see defaultRoutes
+	Routes map[string][]string `toml:"routes"`
+
+	// Rules decide which requests are mocked. Without rules, MockFunctions is used.
+	Rules []Rule `toml:"rules"`
+
+	// ProxyFaults break some of the proxied responses, unless a proxy rule has its own faults
+	ProxyFaults []Fault `toml:"proxy_faults"`
+
+	// LogBodyLimit is the number of bytes of request and response bodies kept in the log
+	// and its file. 0 keeps none.
+	LogBodyLimit int `toml:"log_body_limit"`
+
+	// LogFile keeps the request log across sessions, it is opened at startup
+	LogFile LogFile `toml:"log_file"`
+
+	// Cassettes record proxied responses of the mocked apis and replay them
+	Cassettes Cassettes `toml:"cassettes"`
+
+	// RateLimit limits the mocked requests, unless their rule has its own limit
+	RateLimit RateLimit `toml:"rate_limit"`
+
+	// Profiles are named mock answers, selected by rules
+	Profiles []Profile `toml:"profiles"`
+}
+
+// ToolCall is a mocked function call, Arguments holds the JSON encoded arguments
+type ToolCall struct {
+	Name      string `toml:"name"`
+	Arguments string `toml:"arguments"`
+}
+
+// MockAnswer is a complete mock answer. Rates of 0 use the rates of the config.
+type MockAnswer struct {
+	Thinking      string     `toml:"thinking"`
+	ThinkingRate  int        `toml:"thinking_rate"`
+	Content       string     `toml:"content"`
+	ContentRate   int        `toml:"content_rate"`
+	ToolCalls     []ToolCall `toml:"tool_calls"`
+	ToolCallsRate int        `toml:"tool_calls_rate"`
+	RawMode       bool       `toml:"raw_mode"`
+
+	// Echo replaces the content with the last user message,
+	// EchoTransform is "reverse", "upper", "lower" or empty to send it as is
+	Echo          bool   `toml:"echo"`
+	EchoTransform string `toml:"echo_transform"`
+
+	// Chunking splits thinking and content into streamed chunks, see chunker.Strategies.
+	// Empty uses the chunking of the config. ChunkSize is used by "fixed", ChunkPattern by "regex".
+	Chunking     string `toml:"chunking"`
+	ChunkSize    int    `toml:"chunk_size"`
+	ChunkPattern string `toml:"chunk_pattern"`
+
+	// Latency replaces the latency model of the config
+	Latency *latency.Model `toml:"latency"`
+}
+
+// Profile is a named mock answer
+type Profile struct {
+	Name string `toml:"name"`
+	MockAnswer
+}
+
+// Rule decides how matching requests are handled, the first matching rule wins
+type Rule struct {
+	Name   string    `toml:"name"`
+	Match  RuleMatch `toml:"match"`
+	Action string    `toml:"action"` // "mock" (default), "proxy" or "error"
+
+	// Profile names the answer of the rule, Response gives it inline.
+	// Without both the configured mock answer is used.
+	Profile  string      `toml:"profile"`
+	Response *MockAnswer `toml:"response"`
+
+	// Sequence answers consecutive matching requests with these profiles in turn,
+	// in place of Profile and Response.
+	// SequenceMode "stick" (default) repeats the last profile, "cycle" starts over.
+	// With SessionHeader set, each value of that header has its own position.
+	Sequence      []string `toml:"sequence"`
+	SequenceMode  string   `toml:"sequence_mode"`
+	SessionHeader string   `toml:"session_header"`
+
+	// ErrorStatus and ErrorMessage are returned by the "error" action, in the format of the api
+	ErrorStatus  int    `toml:"error_status"`
+	ErrorMessage string `toml:"error_message"`
+
+	// RateLimit replaces the rate limit of the config for the requests of the rule
+	RateLimit *RateLimit `toml:"rate_limit"`
+
+	// Faults break some of the mocked or, with the "proxy" action, proxied responses.
+	// The first fault that hits applies.
+	Faults []Fault `toml:"faults"`
+}
+
+// Fault breaks a mocked response with a probability, see faultTypes
+type Fault struct {
+	Type        string   `toml:"type"`
+	Probability *float64 `toml:"probability"`  // from 0 to 1, always hits when not set
+	AfterChunks int      `toml:"after_chunks"` // chunks sent before the fault
+	Status      int      `toml:"status"`       // of "http_error" and "stream_error", 500 by default
+	Message     string   `toml:"message"`
+	ContentType string   `toml:"content_type"` // of "wrong_content_type", text/plain by default
+	DelayMs     int      `toml:"delay_ms"`     // of "delay", before every chunk
+	Bytes       int      `toml:"bytes"`        // of "corrupt", 1 by default
+}
+
+// LogFile configures the JSONL request log, an empty path disables it
+type LogFile struct {
+	Path       string `toml:"path"`
+	MaxSizeMB  int    `toml:"max_size_mb"`  // rotate when larger
+	MaxAgeDays int    `toml:"max_age_days"` // rotate when older, and delete rotated files older
+}
+
+// Cassettes configures recording and replaying proxied responses, see cassettes.go
+type Cassettes struct {
+	Mode         string   `toml:"mode"` // "record", "replay" or empty to proxy as usual
+	Dir          string   `toml:"dir"`
+	Speed        float64  `toml:"speed"`         // of replays, 2 is twice as fast, 0 the original timing
+	IgnoreFields []string `toml:"ignore_fields"` // top level body fields that do not tell requests apart
+	// Passthrough mocks or proxies requests without a cassette in replay mode, instead of failing them
+	Passthrough bool `toml:"passthrough"`
+}
+
+// RateLimit is a budget of mocked requests, limits of 0 are not enforced
+type RateLimit struct {
+	RequestsPerMinute int    `toml:"requests_per_minute"`
+	TokensPerMinute   int    `toml:"tokens_per_minute"`
+	Key               string `toml:"key"` // "api_key" (default) or "rule"
+}
+
+// RuleMatch lists the conditions of a rule, all given conditions must hold.
+// Values other than Method and API are regular expressions.
+type RuleMatch struct {
+	Method          string            `toml:"method"`
+	Path            string            `toml:"path"`
+	API             string            `toml:"api"` // a key of Config.Routes
+	Headers         map[string]string `toml:"headers"`
+	Query           map[string]string `toml:"query"`
+	Body            map[string]string `toml:"body"` // dot separated JSON field path, e.g. "messages.0.role"
+	Model           string            `toml:"model"`
+	LastUserMessage string            `toml:"last_user_message"`
+	HasTools        *bool             `toml:"has_tools"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		BackendURL:        "http://localhost:3001",
+		MockContent:       "Hello, I am a mock server.",
+		MockContentRate:   100,
+		MockThinking:      "I am thinking...",
+		MockThinkingRate:  100,
+		MockToolCallsRate: 100,
+		MockFunctions:     "*",
+		MockChunking:      chunker.Line,
+		MockChunkSize:     4,
+		MockEnabled:       true,
+		Port:              defaultPort,
+		Routes:            defaultRoutes(),
+		LogBodyLimit:      64 << 10,
+		LogFile:           LogFile{Path: filepath.Join(filepath.Dir(defaultConfigPath()), "logs", "request-log.jsonl"), MaxSizeMB: 10, MaxAgeDays: 7},
+		Cassettes:         Cassettes{Dir: filepath.Join(filepath.Dir(defaultConfigPath()), "cassettes")},
+	}
+}
+
+// defaultConfigPath is where the GUI keeps its config when -config is not given
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "mock-stream.toml"
+	}
+	return filepath.Join(dir, "mock-stream", "config.toml")
+}
+
+// openLogStore opens the request log file of cfg, nil when it is disabled
+func openLogStore(cfg LogFile) (*recorder.LogStore, error) {
+	if cfg.Path == "" {
+		return nil, nil
+	}
+	return recorder.OpenLogStore(cfg.Path, int64(cfg.MaxSizeMB)<<20, time.Duration(cfg.MaxAgeDays)*24*time.Hour)
+}
+
+// loadConfigFile reads a TOML config. Keys missing from the file keep their default values.
+func loadConfigFile(path string) (Config, error) {
+	cfg := defaultConfig()
+	if _, err := toml.DecodeFile(path, &cfg); err != nil {
+		return cfg, fmt.Errorf("load config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// saveConfigFile writes cfg to path, replacing the file atomically
+func saveConfigFile(path string, cfg Config) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.toml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := toml.NewEncoder(tmp).Encode(cfg); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// applyConfig replaces the running config with cfg loaded from a file.
+// The port can not change while the server is running, it is kept until the next start.
+func applyConfig(cfg Config) (portChanged bool) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	running, port := appConfig.Running, appConfig.Port
+	appConfig = cfg
+	appConfig.Running = running
+	if running && cfg.Port != port {
+		appConfig.Port = port
+		return true
+	}
+	return false
+}
+
+// watchConfigFile calls onChange with the new config whenever the file at path is written.
+// The directory is watched rather than the file, since editors often save by renaming.
+func watchConfigFile(path string, onChange func(Config)) (stop func(), err error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	name := filepath.Clean(path)
+	go func() {
+		// Editors emit several events per save, reload once they settle
+		var debounce *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != name || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				if debounce != nil {
+					debounce.Stop()
+				}
+				debounce = time.AfterFunc(200*time.Millisecond, func() {
+					cfg, err := loadConfigFile(path)
+					if err != nil {
+						if !errors.Is(err, os.ErrNotExist) {
+							fmt.Fprintf(os.Stderr, "config reload: %v\n", err)
+						}
+						return
+					}
+					onChange(cfg)
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				fmt.Fprintf(os.Stderr, "config watcher: %v\n", err)
+			}
+		}
+	}()
+
+	return func() { watcher.Close() }, nil
+}
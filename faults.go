@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// Fault types
+const (
+	faultAbort            = "abort"              // close the connection
+	faultStall            = "stall"              // stop sending but keep the connection open
+	faultMalformedJSON    = "malformed_json"     // cut a chunk in half
+	faultSplitEvent       = "split_event"        // send a chunk in two writes
+	faultOmitDone         = "omit_done"          // leave out the final event of the stream
+	faultStreamError      = "stream_error"       // end the stream with an error event
+	faultWrongContentType = "wrong_content_type" // send another Content-Type
+	faultHTTPError        = "http_error"         // answer with an error status instead
+	faultDelay            = "delay"              // wait before every chunk
+	faultCorrupt          = "corrupt"            // replace random bytes of a chunk
+)
+
+var faultTypes = []string{faultAbort, faultStall, faultMalformedJSON, faultSplitEvent,
+	faultOmitDone, faultStreamError, faultWrongContentType, faultHTTPError, faultDelay, faultCorrupt}
+
+// pickFault rolls the faults in order and returns the first one that hits, if any.
+// Faults that only make sense in a stream are skipped for other requests.
+func pickFault(faults []Fault, stream bool) *Fault {
+	for i := range faults {
+		switch faults[i].Type {
+		case faultSplitEvent, faultOmitDone, faultStreamError:
+			if !stream {
+				continue
+			}
+		}
+		if p := faults[i].Probability; p == nil || rand.Float64() < *p {
+			return &faults[i]
+		}
+	}
+	return nil
+}
+
+// mockedError fills in the defaults of an error status and message
+func mockedError(status int, message string) (int, string) {
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	if message == "" {
+		message = fmt.Sprintf("Mocked error %d %s", status, http.StatusText(status))
+	}
+	return status, message
+}
+
+// faultWriter wraps the writer of a mocked api and injects the fault armed by resolveMock
+// into the first write after AfterChunks chunks.
+type faultWriter struct {
+	http.ResponseWriter
+	r   *http.Request
+	api string
+
+	fault       *Fault
+	afterChunks int
+	chunks      int  // chunks sent by streamChunks, or writes with countWrites
+	countWrites bool // for proxied responses, which are written as they arrive
+	fired       bool // the fault was injected
+	ended       bool // the stream ended with an error, later writes are dropped
+}
+
+func newFaultWriter(w http.ResponseWriter, r *http.Request, api string) *faultWriter {
+	return &faultWriter{ResponseWriter: w, r: r, api: api}
+}
+
+// arm injects fault into the response, answers sent at once break right away
+func (fw *faultWriter) arm(fault *Fault, stream bool) {
+	fw.fault = fault
+	if stream {
+		fw.afterChunks = fault.AfterChunks
+	}
+}
+
+// chunkSent counts the chunks, faults wait for AfterChunks of them
+func (fw *faultWriter) chunkSent() {
+	fw.chunks++
+}
+
+func (fw *faultWriter) WriteHeader(status int) {
+	fw.setContentType()
+	fw.ResponseWriter.WriteHeader(status)
+}
+
+func (fw *faultWriter) Write(p []byte) (int, error) {
+	if fw.countWrites {
+		defer fw.chunkSent()
+	}
+	if fw.ended {
+		return len(p), nil
+	}
+	fault := fw.fault
+	if fault == nil {
+		return fw.ResponseWriter.Write(p)
+	}
+	fw.setContentType()
+	if fault.Type == faultOmitDone && isFinalEvent(fw.api, p) {
+		return len(p), nil
+	}
+	if fw.chunks < fw.afterChunks {
+		return fw.ResponseWriter.Write(p)
+	}
+	if fault.Type == faultDelay {
+		time.Sleep(time.Duration(fault.DelayMs) * time.Millisecond)
+	}
+	if fw.fired {
+		return fw.ResponseWriter.Write(p)
+	}
+
+	switch fault.Type {
+	case faultAbort:
+		fw.fired = true
+		fw.Flush()
+		panic(http.ErrAbortHandler)
+	case faultStall:
+		fw.fired = true
+		fw.Flush()
+		<-fw.r.Context().Done()
+		panic(http.ErrAbortHandler)
+	case faultMalformedJSON:
+		fw.fired = true
+		// Keep the line endings, so the broken JSON still arrives as one event
+		content := bytes.TrimRight(p, "\r\n")
+		broken := append(append([]byte{}, content[:len(content)/2]...), p[len(content):]...)
+		if _, err := fw.ResponseWriter.Write(broken); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	case faultSplitEvent:
+		fw.fired = true
+		half := len(p) / 2
+		n, err := fw.ResponseWriter.Write(p[:half])
+		if err != nil {
+			return n, err
+		}
+		fw.Flush()
+		time.Sleep(50 * time.Millisecond)
+		m, err := fw.ResponseWriter.Write(p[half:])
+		return n + m, err
+	case faultCorrupt:
+		fw.fired = true
+		corrupted := append([]byte{}, p...)
+		for i := 0; i < max(fault.Bytes, 1) && len(corrupted) > 0; i++ {
+			corrupted[rand.IntN(len(corrupted))] ^= byte(1 + rand.IntN(255))
+		}
+		if _, err := fw.ResponseWriter.Write(corrupted); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	case faultStreamError:
+		fw.fired = true
+		status, message := mockedError(fault.Status, fault.Message)
+		writeStreamError(fw.ResponseWriter, fw.api, status, message)
+		fw.Flush()
+		fw.ended = true
+		return len(p), nil
+	}
+	return fw.ResponseWriter.Write(p)
+}
+
+// Flush sends the headers when nothing was written yet, so the Content-Type is set first
+func (fw *faultWriter) Flush() {
+	fw.setContentType()
+	if f, ok := fw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the wrapped writer
+func (fw *faultWriter) Unwrap() http.ResponseWriter {
+	return fw.ResponseWriter
+}
+
+// setContentType replaces the Content-Type for the wrong_content_type fault, before the headers are sent
+func (fw *faultWriter) setContentType() {
+	if fw.fault == nil || fw.fault.Type != faultWrongContentType || fw.fired {
+		return
+	}
+	fw.fired = true
+	contentType := fw.fault.ContentType
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	fw.Header().Set("Content-Type", contentType)
+}
+
+// isFinalEvent reports whether p ends a stream of api: the chunk with the finishReason and the
+// closing ] of Gemini, the done object of Ollama, or the final event of OpenAI, Anthropic or the Responses API
+func isFinalEvent(api string, p []byte) bool {
+	switch api {
+	case apiGemini:
+		return bytes.Contains(p, []byte(`"finishReason"`)) || bytes.Equal(bytes.TrimSpace(p), []byte("]"))
+	case apiOllamaChat, apiOllamaGenerate:
+		return bytes.Contains(p, []byte(`"done":true`))
+	}
+	return bytes.HasPrefix(p, []byte("data: [DONE]")) ||
+		bytes.HasPrefix(p, []byte("event: message_stop\n")) ||
+		bytes.HasPrefix(p, []byte("event: response.completed\n"))
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func probability(p float64) *float64 {
+	return &p
+}
+
+func TestPickFault(t *testing.T) {
+	tests := []struct {
+		name   string
+		faults []Fault
+		stream bool
+		want   string // type of the fault picked, empty for none
+	}{
+		{"none", nil, true, ""},
+		{"no probability always hits", []Fault{{Type: faultAbort}}, true, faultAbort},
+		{"probability 1", []Fault{{Type: faultAbort, Probability: probability(1)}}, true, faultAbort},
+		{"probability 0 never hits", []Fault{{Type: faultAbort, Probability: probability(0)}}, true, ""},
+		{"first hit wins", []Fault{{Type: faultDelay, Probability: probability(0)}, {Type: faultCorrupt}, {Type: faultAbort}}, true, faultCorrupt},
+		{"stream fault in a stream", []Fault{{Type: faultOmitDone}}, true, faultOmitDone},
+		{"stream faults skipped otherwise", []Fault{{Type: faultSplitEvent}, {Type: faultOmitDone}, {Type: faultStreamError}, {Type: faultHTTPError}}, false, faultHTTPError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if fault := pickFault(tt.faults, tt.stream); fault != nil {
+				got = fault.Type
+			}
+			if got != tt.want {
+				t.Errorf("picked %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsFinalEvent(t *testing.T) {
+	tests := []struct {
+		api   string
+		event string
+		final bool
+	}{
+		{apiChatCompletions, "data: [DONE]\n\n", true},
+		{apiChatCompletions, "data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n", false},
+		{apiAnthropicMessage, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n", true},
+		{apiAnthropicMessage, "event: message_delta\ndata: {\"type\":\"message_delta\"}\n\n", false},
+		{apiResponses, "event: response.completed\ndata: {\"type\":\"response.completed\"}\n\n", true},
+		{apiResponses, "event: response.output_text.done\ndata: {}\n\n", false},
+		{apiGemini, "data: {\"candidates\":[{\"finishReason\":\"STOP\"}]}\r\n\r\n", true},
+		{apiGemini, ",\r\n{\"candidates\":[{\"finishReason\":\"STOP\"}]}", true},
+		{apiGemini, "]", true},
+		{apiGemini, "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"Hi\"}]}}]}\r\n\r\n", false},
+		{apiOllamaChat, "{\"message\":{\"content\":\"\"},\"done\":true}\n", true},
+		{apiOllamaChat, "{\"message\":{\"content\":\"Hi\"},\"done\":false}\n", false},
+		{apiOllamaGenerate, "{\"response\":\"\",\"done\":true}\n", true},
+		{apiOllamaGenerate, "{\"response\":\"Hi\",\"done\":false}\n", false},
+	}
+	for _, tt := range tests {
+		if got := isFinalEvent(tt.api, []byte(tt.event)); got != tt.final {
+			t.Errorf("%s %q: final %v, want %v", tt.api, tt.event, got, tt.final)
+		}
+	}
+}
+
+var (
+	openAIEvents = []string{
+		"data: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{\"content\":\" world\"}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n",
+		"data: [DONE]\n\n",
+	}
+	geminiEvents = []string{
+		"data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"Hello\"}]}}]}\r\n\r\n",
+		"data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\" world\"}]},\"finishReason\":\"STOP\"}]}\r\n\r\n",
+	}
+)
+
+// cutInHalf returns the first half of event without its line ending, followed by the line ending
+func cutInHalf(event, ending string) string {
+	content := strings.TrimSuffix(event, ending)
+	return content[:len(content)/2] + ending
+}
+
+// writeFaulty streams events through a faultWriter armed with fault, the way streamChunks does.
+// It returns the response and what the writer panicked with.
+func writeFaulty(ctx context.Context, api string, fault Fault, events []string) (w *httptest.ResponseRecorder, panicked interface{}) {
+	w = httptest.NewRecorder()
+	w.Header().Set("Content-Type", "text/event-stream")
+	fw := newFaultWriter(w, httptest.NewRequest("POST", "/", nil).WithContext(ctx), api)
+	fw.arm(&fault, true)
+	defer func() { panicked = recover() }()
+	for _, event := range events {
+		if fw.ended {
+			break
+		}
+		fw.Write([]byte(event))
+		fw.Flush()
+		fw.chunkSent()
+	}
+	return w, nil
+}
+
+func TestFaultWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		api    string
+		fault  Fault
+		events []string
+		want   string
+		abort  bool
+	}{
+		{"malformed json", apiChatCompletions, Fault{Type: faultMalformedJSON, AfterChunks: 1}, openAIEvents,
+			openAIEvents[0] + cutInHalf(openAIEvents[1], "\n\n") + openAIEvents[2] + openAIEvents[3], false},
+		{"malformed json keeps CRLF", apiGemini, Fault{Type: faultMalformedJSON}, geminiEvents,
+			cutInHalf(geminiEvents[0], "\r\n\r\n") + geminiEvents[1], false},
+		{"split event", apiChatCompletions, Fault{Type: faultSplitEvent, AfterChunks: 2}, openAIEvents,
+			strings.Join(openAIEvents, ""), false},
+		{"omit done", apiChatCompletions, Fault{Type: faultOmitDone}, openAIEvents,
+			strings.Join(openAIEvents[:3], ""), false},
+		{"omit done of gemini", apiGemini, Fault{Type: faultOmitDone}, geminiEvents,
+			geminiEvents[0], false},
+		{"stream error", apiChatCompletions, Fault{Type: faultStreamError, AfterChunks: 1, Status: 503, Message: "Overloaded"}, openAIEvents,
+			openAIEvents[0] + `data: {"error":{"code":null,"message":"Overloaded","param":null,"type":"server_error"}}` + "\n\n", false},
+		{"abort", apiChatCompletions, Fault{Type: faultAbort, AfterChunks: 2}, openAIEvents,
+			strings.Join(openAIEvents[:2], ""), true},
+		{"stall", apiChatCompletions, Fault{Type: faultStall, AfterChunks: 3}, openAIEvents,
+			strings.Join(openAIEvents[:3], ""), true},
+		{"after all chunks", apiChatCompletions, Fault{Type: faultAbort, AfterChunks: 10}, openAIEvents,
+			strings.Join(openAIEvents, ""), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A stall ends when the client goes away
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			w, panicked := writeFaulty(ctx, tt.api, tt.fault, tt.events)
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+			if aborted := panicked == http.ErrAbortHandler; aborted != tt.abort || !aborted && panicked != nil {
+				t.Errorf("panicked with %v, want abort %v", panicked, tt.abort)
+			}
+		})
+	}
+}
+
+func TestFaultWriterCorrupt(t *testing.T) {
+	w, _ := writeFaulty(context.Background(), apiChatCompletions, Fault{Type: faultCorrupt, AfterChunks: 1, Bytes: 3}, openAIEvents)
+	want := strings.Join(openAIEvents, "")
+	got := w.Body.String()
+	if len(got) != len(want) || got == want {
+		t.Fatalf("body = %q, want %q with bytes changed", got, want)
+	}
+	// Only the second event is corrupted
+	if !strings.HasPrefix(got, openAIEvents[0]) || !strings.HasSuffix(got, openAIEvents[2]+openAIEvents[3]) {
+		t.Errorf("body = %q, want only the second event changed", got)
+	}
+}
+
+func TestFaultWriterDelay(t *testing.T) {
+	start := time.Now()
+	w, _ := writeFaulty(context.Background(), apiChatCompletions, Fault{Type: faultDelay, AfterChunks: 2, DelayMs: 20}, openAIEvents)
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("took %s, want the 2 chunks after after_chunks delayed by 20ms", elapsed)
+	}
+	if got := w.Body.String(); got != strings.Join(openAIEvents, "") {
+		t.Errorf("body = %q, want it unchanged", got)
+	}
+}
+
+func TestFaultWriterContentType(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault Fault
+		flush bool // flush before the first write, as with a latency model
+		want  string
+	}{
+		{"default", Fault{Type: faultWrongContentType}, false, "text/plain; charset=utf-8"},
+		{"set", Fault{Type: faultWrongContentType, ContentType: "application/json"}, false, "application/json"},
+		{"flushed first", Fault{Type: faultWrongContentType}, true, "text/plain; charset=utf-8"},
+		{"other fault", Fault{Type: faultOmitDone}, true, "text/event-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			w.Header().Set("Content-Type", "text/event-stream")
+			fw := newFaultWriter(w, httptest.NewRequest("POST", "/", nil), apiChatCompletions)
+			fw.arm(&tt.fault, true)
+			if tt.flush {
+				fw.Flush()
+			}
+			fw.Write([]byte(openAIEvents[0]))
+			if got := w.Result().Header.Get("Content-Type"); got != tt.want {
+				t.Errorf("Content-Type = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Answers sent at once break right away, after_chunks only counts the chunks of streams
+func TestFaultWriterNotStreamed(t *testing.T) {
+	w := httptest.NewRecorder()
+	fw := newFaultWriter(w, httptest.NewRequest("POST", "/", nil), apiChatCompletions)
+	fw.arm(&Fault{Type: faultMalformedJSON, AfterChunks: 5}, false)
+	fw.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion"}` + "\n"))
+	if got := w.Body.String(); got != cutInHalf(`{"id":"chatcmpl-1","object":"chat.completion"}`+"\n", "\n") {
+		t.Errorf("body = %q, want it cut in half", got)
+	}
+}
+
+// streamBackend streams events like a backend does, counting the requests it gets
+func streamBackend(t *testing.T, events []string, requests *int) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			w.Write([]byte(event))
+			w.(http.Flusher).Flush()
+			// Each event reaches the proxy as a write of its own
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestProxyFaults(t *testing.T) {
+	stream := strings.Join(openAIEvents, "")
+	tests := []struct {
+		name        string
+		proxyFaults []Fault
+		ruleFaults  []Fault
+		status      int
+		want        string
+		proxied     bool // the backend got the request
+	}{
+		{"no faults", nil, nil, 200, stream, true},
+		{"http error", []Fault{{Type: faultHTTPError, Status: 503, Message: "Backend down"}}, nil,
+			503, `"message":"Backend down"`, false},
+		{"unknown fault", []Fault{{Type: "explode"}}, nil, 500, `Unknown proxy fault \"explode\"`, false},
+		{"omit done", []Fault{{Type: faultOmitDone}}, nil, 200, strings.Join(openAIEvents[:3], ""), true},
+		{"malformed json after a chunk", []Fault{{Type: faultMalformedJSON, AfterChunks: 1}}, nil,
+			200, openAIEvents[0] + cutInHalf(openAIEvents[1], "\n\n") + openAIEvents[2] + openAIEvents[3], true},
+		{"probability 0", []Fault{{Type: faultHTTPError, Probability: probability(0)}}, nil, 200, stream, true},
+		{"faults of the rule win", []Fault{{Type: faultOmitDone}}, []Fault{{Type: faultHTTPError, Status: 429}}, 429, "Mocked error 429", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			cfg := testConfig()
+			cfg.BackendURL = streamBackend(t, openAIEvents, &requests).URL
+			cfg.ProxyFaults = tt.proxyFaults
+			cfg.Rules = []Rule{{Name: "proxy", Action: actionProxy, Faults: tt.ruleFaults}}
+			useConfig(t, cfg)
+
+			w := serve("POST", "/v1/chat/completions", chatBody, nil)
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("got %d %q, want %d with %q", w.Code, w.Body, tt.status, tt.want)
+			}
+			if tt.status == 200 && w.Body.String() != tt.want {
+				t.Errorf("body = %q, want %q", w.Body, tt.want)
+			}
+			if proxied := requests > 0; proxied != tt.proxied {
+				t.Errorf("proxied %v, want %v", proxied, tt.proxied)
+			}
+		})
+	}
+}
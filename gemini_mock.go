@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"mock-stream/util"
+)
+
+type geminiRequest struct {
+	Contents []geminiContent `json:"contents"`
+}
+
+type geminiContent struct {
+	Role  string `json:"role"`
+	Parts []struct {
+		Text string `json:"text"`
+	} `json:"parts"`
+}
+
+// promptTokens estimates the tokens of the text parts of all contents
+func (r *geminiRequest) promptTokens() int {
+	tokens := 0
+	for _, c := range r.Contents {
+		for _, part := range c.Parts {
+			tokens += util.EstimateTokens(part.Text)
+		}
+	}
+	return tokens
+}
+
+// messages returns the text of the contents as chat messages
+func (r *geminiRequest) messages() []chatMessage {
+	messages := make([]chatMessage, 0, len(r.Contents))
+	for _, c := range r.Contents {
+		var text string
+		for _, part := range c.Parts {
+			text += part.Text
+		}
+		messages = append(messages, textMessage(c.Role, text))
+	}
+	return messages
+}
+
+// handleGemini mocks /v1beta/models/{model}:generateContent and :streamGenerateContent.
+// Other model methods are proxied.
+func handleGemini(w http.ResponseWriter, r *http.Request) {
+	model, method := routeParam(r, "model"), routeParam(r, "method")
+	if method != "generateContent" && method != "streamGenerateContent" {
+		handleProxy(w, r)
+		return
+	}
+
+	var req geminiRequest
+	body, parseErr := readJSONBody(r, &req)
+	stream := method == "streamGenerateContent"
+
+	resp, ok := resolveMock(w, r, &mockRequest{
+		API:      apiGemini,
+		Model:    model,
+		Stream:   stream,
+		Messages: req.messages(),
+		Body:     body,
+	}, parseErr)
+	if !ok {
+		return
+	}
+
+	if !stream {
+		writeGeminiResponse(w, model, &req, resp)
+		return
+	}
+	streamGeminiResponse(w, model, r.URL.Query().Get("alt") == "sse", &req, resp)
+}
+
+// writeGeminiError writes an error in the Google API error format
+func writeGeminiError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(geminiErrorBody(code, message))
+}
+
+func geminiErrorBody(code int, message string) map[string]interface{} {
+	var status string
+	switch code {
+	case http.StatusBadRequest:
+		status = "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		status = "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		status = "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		status = "NOT_FOUND"
+	case http.StatusTooManyRequests:
+		status = "RESOURCE_EXHAUSTED"
+	case http.StatusServiceUnavailable:
+		status = "UNAVAILABLE"
+	case http.StatusGatewayTimeout:
+		status = "DEADLINE_EXCEEDED"
+	default:
+		status = "INTERNAL"
+	}
+
+	return map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"status":  status,
+		},
+	}
+}
+
+// geminiChunk builds a GenerateContentResponse holding parts, the final one carries finishReason and usage
+func geminiChunk(model, responseID string, parts []interface{}, final bool, req *geminiRequest, resp mockResponse) map[string]interface{} {
+	candidate := map[string]interface{}{
+		"content": map[string]interface{}{
+			"role":  "model",
+			"parts": parts,
+		},
+		"index": 0,
+	}
+	chunk := map[string]interface{}{
+		"candidates":   []interface{}{candidate},
+		"modelVersion": model,
+		"responseId":   responseID,
+	}
+	if final {
+		candidate["finishReason"] = "STOP"
+		promptTokens := req.promptTokens()
+		thoughtsTokens := util.EstimateTokens(resp.Thinking)
+		candidatesTokens := resp.completionTokens() - thoughtsTokens
+		chunk["usageMetadata"] = map[string]int{
+			"promptTokenCount":     promptTokens,
+			"candidatesTokenCount": candidatesTokens,
+			"thoughtsTokenCount":   thoughtsTokens,
+			"totalTokenCount":      promptTokens + candidatesTokens + thoughtsTokens,
+		}
+	}
+	return chunk
+}
+
+func functionCallPart(call ToolCall) map[string]interface{} {
+	return map[string]interface{}{
+		"functionCall": map[string]interface{}{
+			"name": call.Name,
+			"args": call.argumentsObject(),
+		},
+	}
+}
+
+func writeGeminiResponse(w http.ResponseWriter, model string, req *geminiRequest, resp mockResponse) {
+	if resp.RawMode {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, resp.Thinking+resp.Content)
+		return
+	}
+
+	parts := []interface{}{}
+	if resp.Thinking != "" {
+		parts = append(parts, map[string]interface{}{"text": resp.Thinking, "thought": true})
+	}
+	if resp.Content != "" {
+		parts = append(parts, map[string]interface{}{"text": resp.Content})
+	}
+	for _, call := range resp.ToolCalls {
+		parts = append(parts, functionCallPart(call))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(geminiChunk(model, util.RandomID("", 24), parts, true, req, resp))
+}
+
+// geminiStream writes responses as server-sent events (alt=sse) or as elements of one JSON array
+type geminiStream struct {
+	w       http.ResponseWriter
+	sse     bool
+	rawMode bool
+	count   int
+}
+
+func (s *geminiStream) send(chunk map[string]interface{}) {
+	jsonData, _ := json.Marshal(chunk)
+	switch {
+	case s.sse:
+		fmt.Fprintf(s.w, "data: %s\r\n\r\n", jsonData)
+	case s.count == 0:
+		fmt.Fprintf(s.w, "[%s", jsonData)
+	default:
+		fmt.Fprintf(s.w, ",\r\n%s", jsonData)
+	}
+	s.count++
+}
+
+func streamGeminiResponse(w http.ResponseWriter, model string, sse bool, req *geminiRequest, resp mockResponse) {
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s := &geminiStream{w: w, sse: sse, rawMode: resp.RawMode}
+	responseID := util.RandomID("", 24)
+
+	sendParts := func(chunks []string, p pace, part func(ch string) map[string]interface{}) {
+		streamChunks(w, chunks, p, func(ch string) {
+			if s.rawMode {
+				fmt.Fprintf(w, "%s\n", ch)
+				return
+			}
+			s.send(geminiChunk(model, responseID, []interface{}{part(ch)}, false, req, resp))
+		})
+	}
+	sendParts(resp.split(resp.Thinking), resp.thinkingPace(), func(ch string) map[string]interface{} {
+		return map[string]interface{}{"text": ch, "thought": true}
+	})
+	sendParts(resp.split(resp.Content), resp.contentPace(), func(ch string) map[string]interface{} {
+		return map[string]interface{}{"text": ch}
+	})
+	// Gemini does not split function calls, each one arrives whole
+	for _, call := range resp.ToolCalls {
+		sendParts([]string{call.Name}, resp.toolCallsPace(), func(string) map[string]interface{} {
+			return functionCallPart(call)
+		})
+	}
+
+	if !s.rawMode {
+		s.send(geminiChunk(model, responseID, []interface{}{map[string]string{"text": ""}}, true, req, resp))
+		if !sse {
+			fmt.Fprint(w, "]")
+		}
+	}
+	w.(http.Flusher).Flush()
+}
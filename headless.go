@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"mock-stream/recorder"
+)
+
+type cliOptions struct {
+	headless   bool
+	configPath string
+}
+
+// newFlagSet binds the command line flags to cfg and opts
+func newFlagSet(cfg *Config, opts *cliOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.BoolVar(&opts.headless, "headless", false, "run the mock server without the window")
+	fs.StringVar(&opts.configPath, "config", "", "TOML config file, watched for changes (default: "+defaultConfigPath()+" in GUI mode)")
+	fs.StringVar(&cfg.BackendURL, "backend", cfg.BackendURL, "proxy url for requests that are not mocked")
+	fs.StringVar(&cfg.MockContent, "content", cfg.MockContent, "mock content")
+	fs.IntVar(&cfg.MockContentRate, "content-rate", cfg.MockContentRate, "delay between content chunks (ms)")
+	fs.StringVar(&cfg.MockThinking, "thinking", cfg.MockThinking, "mock reasoning content")
+	fs.IntVar(&cfg.MockThinkingRate, "thinking-rate", cfg.MockThinkingRate, "delay between reasoning chunks (ms)")
+	fs.StringVar(&cfg.MockFunctions, "functions", cfg.MockFunctions, "comma separated FunctionName values to mock, * for all")
+	fs.StringVar(&cfg.MockChunking, "chunking", cfg.MockChunking, "how thinking and content are split into chunks: line, rune, word, fixed, regex or bpe")
+	fs.IntVar(&cfg.MockChunkSize, "chunk-size", cfg.MockChunkSize, "characters per chunk for fixed chunking")
+	fs.StringVar(&cfg.MockChunkPattern, "chunk-pattern", cfg.MockChunkPattern, "regex ending each chunk for regex chunking")
+	fs.IntVar(&cfg.Latency.TTFB, "ttfb", cfg.Latency.TTFB, "delay before the response headers (ms)")
+	fs.IntVar(&cfg.Latency.TTFT, "ttft", cfg.Latency.TTFT, "delay between the headers and the first chunk (ms)")
+	fs.Float64Var(&cfg.Latency.TokensPerSecond, "tokens-per-second", cfg.Latency.TokensPerSecond, "pace chunks by their estimated tokens instead of the rates")
+	fs.BoolVar(&cfg.MockEcho, "echo", cfg.MockEcho, "answer with the last user message instead of the mock content")
+	fs.StringVar(&cfg.MockEchoTransform, "echo-transform", cfg.MockEchoTransform, "transform of the echoed message: reverse, upper or lower")
+	fs.BoolVar(&cfg.MockEnabled, "mock", cfg.MockEnabled, "enable mocking, otherwise every request is proxied")
+	fs.BoolVar(&cfg.RawMode, "raw", cfg.RawMode, "return raw lines instead of \"data: {...}\"")
+	fs.StringVar(&cfg.Cassettes.Mode, "cassettes", cfg.Cassettes.Mode, "record proxied responses to cassettes or replay them: record or replay")
+	fs.StringVar(&cfg.Cassettes.Dir, "cassette-dir", cfg.Cassettes.Dir, "directory of the cassettes")
+	fs.BoolVar(&cfg.Cassettes.Passthrough, "cassette-passthrough", cfg.Cassettes.Passthrough, "in replay mode, mock or proxy requests without a cassette instead of failing them")
+	fs.Float64Var(&cfg.Cassettes.Speed, "replay-speed", cfg.Cassettes.Speed, "replay speed factor, 2 is twice as fast")
+	fs.StringVar(&cfg.LogFile.Path, "log-file", cfg.LogFile.Path, "JSONL file keeping the request log, empty to disable")
+	fs.IntVar(&cfg.LogBodyLimit, "log-body-limit", cfg.LogBodyLimit, "bytes of request and response bodies kept in the log, 0 keeps none")
+	fs.IntVar(&cfg.Port, "port", cfg.Port, "server port")
+	return fs
+}
+
+// parseFlags builds the initial config from the config file and the command line.
+// Flags given explicitly override the values from the file.
+func parseFlags() (Config, cliOptions) {
+	var opts cliOptions
+	cfg := defaultConfig()
+	newFlagSet(&cfg, &opts).Parse(os.Args[1:])
+
+	explicit := opts.configPath != ""
+	if !explicit {
+		if opts.headless {
+			return cfg, opts
+		}
+		opts.configPath = defaultConfigPath()
+	}
+
+	fileCfg, err := loadConfigFile(opts.configPath)
+	if err != nil {
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		return cfg, opts
+	}
+	return applyFlags(fileCfg), opts
+}
+
+// applyFlags returns cfg with the flags given explicitly on the command line, they win over the file.
+// Headless mode applies them again whenever the file is reloaded.
+func applyFlags(cfg Config) Config {
+	fs := newFlagSet(&cfg, &cliOptions{})
+	fs.SetOutput(io.Discard)
+	fs.Parse(os.Args[1:])
+	return cfg
+}
+
+// runHeadless serves until SIGINT/SIGTERM and returns the process exit code.
+func runHeadless(cfg Config, configPath string) int {
+	requestLogger = recorder.NewRequestLogger(100)
+	requestLogger.SetOutput(os.Stdout)
+	if store, err := openLogStore(cfg.LogFile); err != nil {
+		fmt.Fprintf(os.Stderr, "request log: %v\n", err)
+	} else if store != nil {
+		requestLogger.SetStore(store)
+		defer store.Close()
+	}
+
+	configMutex.Lock()
+	appConfig = cfg
+	appConfig.Running = true
+	err := startServer()
+	configMutex.Unlock()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start server: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Mock server listening on :%d (backend: %s)\n", cfg.Port, cfg.BackendURL)
+
+	if configPath != "" {
+		stop, err := watchConfigFile(configPath, func(cfg Config) {
+			if applyConfig(applyFlags(cfg)) {
+				fmt.Println("Config reloaded, port change takes effect after restart")
+			} else {
+				fmt.Println("Config reloaded")
+			}
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "watch config: %v\n", err)
+		} else {
+			defer stop()
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	fmt.Printf("Received %s, shutting down\n", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		// Streams still running after the grace period are cut off
+		fmt.Fprintf(os.Stderr, "graceful shutdown interrupted: %v\n", err)
+		server.Close()
+	}
+	return 0
+}
@@ -0,0 +1,112 @@
+package latency
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Jitter distributions
+const (
+	Uniform = "uniform" // evenly within ±JitterMs
+	Normal  = "normal"  // standard deviation of JitterMs
+	Pareto  = "pareto"  // only later, with a long tail of scale JitterMs
+)
+
+// Model describes how the chunks of a stream are timed. All durations are in ms.
+type Model struct {
+	TTFB  int `toml:"ttfb"` // before the response headers
+	TTFT  int `toml:"ttft"` // between the headers and the first chunk
+	Phase     // timing of the answer
+
+	// Reasoning times the thinking, the answer timing is used when not set
+	Reasoning *Phase `toml:"reasoning"`
+}
+
+// Phase is the timing of the chunks of either the thinking or the answer
+type Phase struct {
+	// TokensPerSecond paces chunks by their estimated tokens, 0 keeps the fixed rate
+	TokensPerSecond float64 `toml:"tokens_per_second"`
+	Jitter          string  `toml:"jitter"`
+	JitterMs        int     `toml:"jitter_ms"`
+	// StallProbability is the chance of waiting StallMs more before a chunk
+	StallProbability float64 `toml:"stall_probability"`
+	StallMs          int     `toml:"stall_ms"`
+	// Pause is waited before the first chunk of the phase, when other chunks came before
+	Pause int `toml:"pause"`
+}
+
+// Enabled reports whether m differs from the plain fixed rate timing
+func (m *Model) Enabled() bool {
+	return m.TTFB > 0 || m.TTFT > 0 || m.Phase != (Phase{}) || m.Reasoning != nil
+}
+
+// Timer times the chunks of one response
+type Timer struct {
+	model     *Model
+	started   bool
+	reasoning bool
+}
+
+func NewTimer(m *Model) *Timer {
+	return &Timer{model: m}
+}
+
+// TTFB returns the wait before the response headers
+func (t *Timer) TTFB() time.Duration {
+	return ms(float64(t.model.TTFB))
+}
+
+// Delay returns the wait before a chunk of about tokens tokens.
+// rate is the fixed delay per chunk used without TokensPerSecond.
+func (t *Timer) Delay(tokens int, rate time.Duration, reasoning bool) time.Duration {
+	phase := &t.model.Phase
+	if reasoning && t.model.Reasoning != nil {
+		phase = t.model.Reasoning
+	}
+
+	var delay float64
+	switch {
+	case !t.started:
+		delay = float64(t.model.TTFT)
+	case reasoning != t.reasoning:
+		delay = float64(phase.Pause)
+	case phase.TokensPerSecond > 0:
+		delay = float64(tokens) * 1000 / phase.TokensPerSecond
+	default:
+		delay = float64(rate.Milliseconds())
+	}
+	t.started, t.reasoning = true, reasoning
+
+	delay += phase.jitter()
+	if phase.StallProbability > 0 && rand.Float64() < phase.StallProbability {
+		delay += float64(phase.StallMs)
+	}
+	return ms(delay)
+}
+
+// jitter returns a random offset in ms
+func (p *Phase) jitter() float64 {
+	spread := float64(p.JitterMs)
+	if spread <= 0 {
+		return 0
+	}
+	switch p.Jitter {
+	case Uniform:
+		return (rand.Float64()*2 - 1) * spread
+	case Normal:
+		return rand.NormFloat64() * spread
+	case Pareto:
+		// Shape 1.5: most offsets are small, a few are many times the scale
+		offset := spread * (math.Pow(1-rand.Float64(), -1/1.5) - 1)
+		return math.Min(offset, 100*spread)
+	}
+	return 0
+}
+
+func ms(v float64) time.Duration {
+	if v <= 0 {
+		return 0
+	}
+	return time.Duration(v * float64(time.Millisecond))
+}
@@ -1,13 +1,14 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,23 +20,11 @@ import (
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/widget"
 
+	"mock-stream/chunker"
 	"mock-stream/recorder"
 	"mock-stream/ui"
 )
 
-type Config struct {
-	BackendURL       string
-	MockContent      string
-	MockContentRate  int
-	MockThinking     string
-	MockThinkingRate int
-	MockFunctions    string
-	Running          bool
-	MockEnabled      bool
-	RawMode          bool // return raw line instead of "data: {...}"
-	Port             int
-}
-
 var (
 	configMutex sync.RWMutex
 	appConfig   Config
@@ -49,6 +38,11 @@ var (
 )
 
 func main() {
+	initial, opts := parseFlags()
+	if opts.headless {
+		os.Exit(runHeadless(initial, opts.configPath))
+	}
+
 	myApp := app.New()
 	myApp.SetIcon(ResourceAppIconPng)
 	window := myApp.NewWindow("OpenAI Mock Server")
@@ -56,15 +50,23 @@ func main() {
 
 	// Initialize logger
 	requestLogger = recorder.NewRequestLogger(100)
+	logStore, err := openLogStore(initial.LogFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "request log: %v\n", err)
+	} else if logStore != nil {
+		requestLogger.SetStore(logStore)
+		defer logStore.Close()
+	}
+	appConfig = initial
 
 	// GUI
 	backendEntry := widget.NewEntry()
 	backendEntry.SetPlaceHolder("Input proxy url(.e.g. http://localhost:3001)")
-	backendEntry.SetText("http://localhost:3001")
+	backendEntry.SetText(initial.BackendURL)
 
 	contentEntry := widget.NewMultiLineEntry()
 	contentEntry.SetPlaceHolder("Input content (Click ⇥ button to insert tab)")
-	contentEntry.SetText("Hello, I am a mock server.")
+	contentEntry.SetText(initial.MockContent)
 	contentScroll := container.NewScroll(contentEntry)
 	contentScroll.SetMinSize(fyne.NewSize(380, 200))
 
@@ -72,11 +74,18 @@ func main() {
 		contentEntry.TypedRune('⇥')
 	})
 	contentContainer := container.NewVBox(contentScroll)
-	contentRatePicker := ui.NewNumberPicker("Rate(ms)", 100, 1, 1000, false)
+	contentRatePicker := ui.NewNumberPicker("Rate(ms)", initial.MockContentRate, 1, 1000, false)
+	echoCheck := widget.NewCheck("Echo", nil)
+	echoTransformSelect := widget.NewSelect(append([]string{echoNone}, echoTransforms...), nil)
+	echoTransformSelect.SetSelected(echoTransformOption(initial.MockEchoTransform))
+	echoCheck.SetChecked(initial.MockEcho)
+	if initial.MockEcho {
+		contentEntry.Disable()
+	}
 
 	thinkingEntry := widget.NewMultiLineEntry()
 	thinkingEntry.SetPlaceHolder("Input reasoning content (Click ⇥ button to insert tab)")
-	thinkingEntry.SetText("I am thinking...")
+	thinkingEntry.SetText(initial.MockThinking)
 	thinkingScroll := container.NewScroll(thinkingEntry)
 	thinkingScroll.SetMinSize(fyne.NewSize(380, 200))
 
@@ -84,7 +93,19 @@ func main() {
 		thinkingEntry.TypedRune('⇥')
 	})
 	thinkingContainer := container.NewVBox(thinkingScroll)
-	thinkingRatePicker := ui.NewNumberPicker("Rate(ms)", 100, 1, 1000, false)
+	thinkingRatePicker := ui.NewNumberPicker("Rate(ms)", initial.MockThinkingRate, 1, 1000, false)
+
+	chunkingSelect := widget.NewSelect(chunker.Strategies, nil)
+	chunkingSelect.SetSelected(initial.MockChunking)
+	chunkSizePicker := ui.NewNumberPicker("Size", initial.MockChunkSize, 1, 100, false)
+	chunkPatternEntry := widget.NewEntry()
+	chunkPatternEntry.SetPlaceHolder(`Regex ending each chunk for regex chunking (.e.g. [.!?]\s*)`)
+	chunkPatternEntry.SetText(initial.MockChunkPattern)
+
+	toolCallsEditor := ui.NewPairListEditor("Function name (.e.g. get_weather)", `Arguments JSON (.e.g. {"city": "Paris"})`)
+	toolCallsEditor.SetPairs(toolCallsToPairs(initial.MockToolCalls))
+	addToolCallButton := toolCallsEditor.AddButton("Add Tool Call")
+	toolCallsRatePicker := ui.NewNumberPicker("Rate(ms)", initial.MockToolCallsRate, 1, 1000, false)
 
 	statusLabel := widget.NewLabel("Server Status: Not Running")
 	statusLabel.TextStyle = fyne.TextStyle{Bold: true}
@@ -96,18 +117,26 @@ func main() {
 		appConfig.MockEnabled = checked
 		configMutex.Unlock()
 	})
-	mockSwitch.SetChecked(true)
+	mockSwitch.SetChecked(initial.MockEnabled)
 
 	rawModeSwitch := widget.NewCheck("Raw Mode", func(checked bool) {
 		configMutex.Lock()
 		appConfig.RawMode = checked
 		configMutex.Unlock()
 	})
-	rawModeSwitch.SetChecked(false)
+	rawModeSwitch.SetChecked(initial.RawMode)
 
 	mockFunctions := widget.NewEntry()
 	mockFunctions.SetPlaceHolder("Input mock functions(.e.g. chat,codebase), use * to mock all functions")
-	mockFunctions.SetText("*")
+	mockFunctions.SetText(initial.MockFunctions)
+
+	profileSelect := widget.NewSelect(profileNames(initial.Profiles), nil)
+	profileSelect.PlaceHolder = "Select a profile to load it into the editors below"
+	saveProfileButton := widget.NewButton("Save As", nil)
+	deleteProfileButton := widget.NewButton("Delete", nil)
+	resetSequencesButton := widget.NewButton("Reset Sequences", func() {
+		resetSequences()
+	})
 
 	// Create section headers with custom styling
 	createHeader := func(text string, canvasObjects ...fyne.CanvasObject) *fyne.Container {
@@ -131,10 +160,16 @@ func main() {
 		),
 		createHeader("Mock Functions"),
 		container.NewPadded(mockFunctions),
+		createHeader("Mock Profiles", saveProfileButton, deleteProfileButton, resetSequencesButton),
+		container.NewPadded(profileSelect),
+		createHeader("Mock Chunking", chunkingSelect, chunkSizePicker.GetUI()),
+		container.NewPadded(chunkPatternEntry),
 		createHeader("Mock Thinking", thinkingTabButton, thinkingRatePicker.GetUI()),
 		container.NewPadded(thinkingContainer),
-		createHeader("Mock Content", tabButton, contentRatePicker.GetUI()),
+		createHeader("Mock Content", tabButton, contentRatePicker.GetUI(), echoCheck, echoTransformSelect),
 		container.NewPadded(contentContainer),
+		createHeader("Mock Tool Calls", addToolCallButton, toolCallsRatePicker.GetUI()),
+		container.NewPadded(toolCallsEditor.GetUI()),
 	)
 
 	reqLogList = widget.NewList(
@@ -148,6 +183,9 @@ func main() {
 		},
 		func(id widget.ListItemID, item fyne.CanvasObject) {
 			log := requestLogger.GetLog(id)
+			if log == nil {
+				return
+			}
 
 			// Format the list item text
 			var status string
@@ -167,8 +205,14 @@ func main() {
 				path = "---"
 			}
 
-			item.(*widget.Label).SetText(fmt.Sprintf("%s %s %s %s",
-				log.Timestamp, status, method, path))
+			text := fmt.Sprintf("%s %s %s %s", log.Timestamp, status, method, path)
+			if log.Pinned {
+				text = "📌 " + text
+			}
+			if len(log.Tags) > 0 {
+				text += " #" + strings.Join(log.Tags, " #")
+			}
+			item.(*widget.Label).SetText(text)
 		},
 	)
 
@@ -176,6 +220,10 @@ func main() {
 
 	reqLogList.OnSelected = func(id widget.ListItemID) {
 		log := requestLogger.GetLog(id)
+		if log == nil {
+			reqLogList.Unselect(id)
+			return
+		}
 
 		// Create a selectable text entry with better styling
 		textEntry := widget.NewMultiLineEntry()
@@ -188,9 +236,27 @@ func main() {
 		scroll := container.NewScroll(textEntry)
 		scroll.SetMinSize(fyne.NewSize(500, 400))
 
+		pinCheck := widget.NewCheck("Pin", nil)
+		pinCheck.SetChecked(log.Pinned)
+		pinCheck.OnChanged = func(checked bool) {
+			requestLogger.SetPinned(log, checked)
+		}
+		tagsEntry := widget.NewEntry()
+		tagsEntry.SetPlaceHolder("Tags, comma separated")
+		tagsEntry.SetText(strings.Join(log.Tags, ", "))
+		// Tags are set once they are entered, not on every key, as that saves the entry again
+		setTags := func(text string) {
+			if tags := recorder.ParseTags(text); !slices.Equal(tags, log.Tags) {
+				requestLogger.SetTags(log, tags)
+			}
+		}
+		tagsEntry.OnSubmitted = setTags
+
 		// Create a custom dialog with the scrollable text
-		d := dialog.NewCustom("Log Details", "Close", scroll, window)
+		content := container.NewBorder(container.NewBorder(nil, nil, pinCheck, nil, tagsEntry), nil, nil, nil, scroll)
+		d := dialog.NewCustom("Log Details", "Close", content, window)
 		d.SetOnClosed(func() {
+			setTags(tagsEntry.Text)
 			// Reset the selection after dialog is closed
 			reqLogList.Unselect(id)
 		})
@@ -200,6 +266,16 @@ func main() {
 	logScroll := container.NewScroll(reqLogList)
 	logScroll.SetMinSize(fyne.NewSize(380, 200))
 
+	loadHistoryButton := widget.NewButton("Load History", func() {
+		if _, err := requestLogger.LoadHistory(100); err != nil {
+			dialog.ShowError(err, window)
+		}
+	})
+	if logStore == nil {
+		loadHistoryButton.Disable()
+	}
+	logsPage := container.NewBorder(container.NewVBox(container.NewHBox(loadHistoryButton), newLogFilterBar()), nil, nil, nil, logScroll)
+
 	// EVENT HANDLER
 	backendEntry.OnChanged = func(text string) {
 		configMutex.Lock()
@@ -225,7 +301,138 @@ func main() {
 		configMutex.Unlock()
 	}
 
-	portPicker = ui.NewPortPicker("Server Port", defaultPort)
+	contentRatePicker.SetOnChanged(func(rate int) {
+		configMutex.Lock()
+		appConfig.MockContentRate = rate
+		configMutex.Unlock()
+	})
+
+	thinkingRatePicker.SetOnChanged(func(rate int) {
+		configMutex.Lock()
+		appConfig.MockThinkingRate = rate
+		configMutex.Unlock()
+	})
+
+	echoCheck.OnChanged = func(checked bool) {
+		configMutex.Lock()
+		appConfig.MockEcho = checked
+		configMutex.Unlock()
+		// The echoed message replaces the content
+		if checked {
+			contentEntry.Disable()
+		} else {
+			contentEntry.Enable()
+		}
+	}
+
+	echoTransformSelect.OnChanged = func(option string) {
+		configMutex.Lock()
+		appConfig.MockEchoTransform = echoTransformValue(option)
+		configMutex.Unlock()
+	}
+
+	chunkingSelect.OnChanged = func(strategy string) {
+		configMutex.Lock()
+		appConfig.MockChunking = strategy
+		configMutex.Unlock()
+	}
+
+	chunkSizePicker.SetOnChanged(func(size int) {
+		configMutex.Lock()
+		appConfig.MockChunkSize = size
+		configMutex.Unlock()
+	})
+
+	chunkPatternEntry.OnChanged = func(text string) {
+		configMutex.Lock()
+		appConfig.MockChunkPattern = text
+		configMutex.Unlock()
+	}
+
+	toolCallsEditor.SetOnChanged(func(pairs []ui.Pair) {
+		configMutex.Lock()
+		appConfig.MockToolCalls = pairsToToolCalls(pairs)
+		configMutex.Unlock()
+	})
+
+	toolCallsRatePicker.SetOnChanged(func(rate int) {
+		configMutex.Lock()
+		appConfig.MockToolCallsRate = rate
+		configMutex.Unlock()
+	})
+
+	// Show a mock answer in the editors, their handlers update appConfig
+	showAnswer := func(a MockAnswer) {
+		thinkingEntry.SetText(a.Thinking)
+		contentEntry.SetText(a.Content)
+		toolCallsEditor.SetPairs(toolCallsToPairs(a.ToolCalls))
+		rawModeSwitch.SetChecked(a.RawMode)
+		echoCheck.SetChecked(a.Echo)
+		echoTransformSelect.SetSelected(echoTransformOption(a.EchoTransform))
+		if a.Chunking != "" {
+			chunkingSelect.SetSelected(a.Chunking)
+			chunkSizePicker.SetValue(a.ChunkSize)
+			chunkPatternEntry.SetText(a.ChunkPattern)
+		}
+		if a.ThinkingRate > 0 {
+			thinkingRatePicker.SetValue(a.ThinkingRate)
+		}
+		if a.ContentRate > 0 {
+			contentRatePicker.SetValue(a.ContentRate)
+		}
+		if a.ToolCallsRate > 0 {
+			toolCallsRatePicker.SetValue(a.ToolCallsRate)
+		}
+	}
+
+	profileSelect.OnChanged = func(name string) {
+		configMutex.RLock()
+		profile, ok := findProfile(appConfig.Profiles, name)
+		configMutex.RUnlock()
+		if ok {
+			showAnswer(profile.MockAnswer)
+		}
+	}
+
+	saveProfileButton.OnTapped = func() {
+		nameEntry := widget.NewEntry()
+		nameEntry.SetText(profileSelect.Selected)
+		items := []*widget.FormItem{widget.NewFormItem("Name", nameEntry)}
+		dialog.ShowForm("Save Editors As Profile", "Save", "Cancel", items, func(ok bool) {
+			name := strings.TrimSpace(nameEntry.Text)
+			if !ok || name == "" {
+				return
+			}
+			configMutex.Lock()
+			appConfig.Profiles = saveProfile(appConfig.Profiles, Profile{Name: name, MockAnswer: configAnswer(&appConfig)})
+			names := profileNames(appConfig.Profiles)
+			configMutex.Unlock()
+
+			profileSelect.SetOptions(names)
+			profileSelect.SetSelected(name)
+		}, window)
+	}
+
+	deleteProfileButton.OnTapped = func() {
+		name := profileSelect.Selected
+		if name == "" {
+			return
+		}
+		dialog.ShowConfirm("Delete Profile", fmt.Sprintf("Delete profile %q?", name), func(ok bool) {
+			if !ok {
+				return
+			}
+			configMutex.Lock()
+			appConfig.Profiles = deleteProfile(appConfig.Profiles, name)
+			names := profileNames(appConfig.Profiles)
+			configMutex.Unlock()
+
+			profileSelect.ClearSelected()
+			profileSelect.SetOptions(names)
+		}, window)
+	}
+
+	portPicker = ui.NewPortPicker("Server Port", initial.Port)
 	startButton.OnTapped = func() {
 		configMutex.Lock()
 		defer configMutex.Unlock()
@@ -237,35 +444,101 @@ func main() {
 			startButton.SetText("Start Server ▶️")
 			portPicker.Enable()
 		} else {
-			appConfig = Config{
-				BackendURL:       backendEntry.Text,
-				MockContent:      contentEntry.Text,
-				MockContentRate:  contentRatePicker.GetValue(),
-				MockThinking:     thinkingEntry.Text,
-				MockThinkingRate: thinkingRatePicker.GetValue(),
-				MockEnabled:      mockSwitch.Checked,
-				RawMode:          rawModeSwitch.Checked,
-				MockFunctions:    mockFunctions.Text,
-				Port:             portPicker.GetValue(),
-				Running:          true,
+			// Fields without a widget come from the config file and are kept
+			appConfig.BackendURL = backendEntry.Text
+			appConfig.MockContent = contentEntry.Text
+			appConfig.MockContentRate = contentRatePicker.GetValue()
+			appConfig.MockEcho = echoCheck.Checked
+			appConfig.MockEchoTransform = echoTransformValue(echoTransformSelect.Selected)
+			appConfig.MockChunking = chunkingSelect.Selected
+			appConfig.MockChunkSize = chunkSizePicker.GetValue()
+			appConfig.MockChunkPattern = chunkPatternEntry.Text
+			appConfig.MockThinking = thinkingEntry.Text
+			appConfig.MockThinkingRate = thinkingRatePicker.GetValue()
+			appConfig.MockToolCalls = pairsToToolCalls(toolCallsEditor.GetPairs())
+			appConfig.MockToolCallsRate = toolCallsRatePicker.GetValue()
+			appConfig.MockEnabled = mockSwitch.Checked
+			appConfig.RawMode = rawModeSwitch.Checked
+			appConfig.MockFunctions = mockFunctions.Text
+			appConfig.Port = portPicker.GetValue()
+			appConfig.Running = true
+			if err := startServer(); err != nil {
+				appConfig.Running = false
+				dialog.ShowError(err, window)
+				return
 			}
-			startServer()
 			statusLabel.SetText(fmt.Sprintf("Server Status: Started (Port:%d)", appConfig.Port))
 			startButton.SetText("Stop Server 🔴")
 			portPicker.Disable()
 		}
 	}
 
-	mainPage := container.NewVBox(
-		container.NewPadded(form),
+	saveButton := widget.NewButton("Save Config 💾", func() {
+		configMutex.RLock()
+		cfg := appConfig
+		configMutex.RUnlock()
+		if !cfg.Running {
+			cfg.Port = portPicker.GetValue()
+		}
+		if err := saveConfigFile(opts.configPath, cfg); err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		dialog.ShowInformation("Config Saved", opts.configPath, window)
+	})
+
+	// Show config file edits in the widgets, their handlers update appConfig
+	showConfig := func(cfg Config) {
+		backendEntry.SetText(cfg.BackendURL)
+		contentEntry.SetText(cfg.MockContent)
+		contentRatePicker.SetValue(cfg.MockContentRate)
+		echoCheck.SetChecked(cfg.MockEcho)
+		echoTransformSelect.SetSelected(echoTransformOption(cfg.MockEchoTransform))
+		chunkingSelect.SetSelected(cfg.MockChunking)
+		chunkSizePicker.SetValue(cfg.MockChunkSize)
+		chunkPatternEntry.SetText(cfg.MockChunkPattern)
+		thinkingEntry.SetText(cfg.MockThinking)
+		thinkingRatePicker.SetValue(cfg.MockThinkingRate)
+		toolCallsEditor.SetPairs(toolCallsToPairs(cfg.MockToolCalls))
+		toolCallsRatePicker.SetValue(cfg.MockToolCallsRate)
+		mockSwitch.SetChecked(cfg.MockEnabled)
+		rawModeSwitch.SetChecked(cfg.RawMode)
+		mockFunctions.SetText(cfg.MockFunctions)
+		profileSelect.SetOptions(profileNames(cfg.Profiles))
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.configPath), 0o755); err == nil {
+		// Flags only set the initial values of the window, a saved config file wins over them
+		stop, err := watchConfigFile(opts.configPath, func(cfg Config) {
+			portChanged := applyConfig(cfg)
+			configMutex.RLock()
+			running, port := appConfig.Running, appConfig.Port
+			configMutex.RUnlock()
+			fyne.Do(func() {
+				showConfig(cfg)
+				if portChanged {
+					statusLabel.SetText(fmt.Sprintf("Server Status: Started (Port:%d), restart to use port %d", port, cfg.Port))
+				} else if !running {
+					portPicker.SetValue(cfg.Port)
+				}
+			})
+		})
+		if err == nil {
+			defer stop()
+		}
+	}
+
+	serverControls := container.NewVBox(
 		container.NewPadded(statusLabel),
 		container.NewPadded(portPicker.GetUI()),
-		container.NewPadded(startButton),
+		container.NewPadded(container.NewGridWithColumns(2, startButton, saveButton)),
+	)
+	mainPage := container.NewBorder(nil, serverControls, nil, nil,
+		container.NewVScroll(container.NewPadded(form)),
 	)
 
 	tabs := container.NewAppTabs(
 		container.NewTabItem("Mock", mainPage),
-		container.NewTabItem("Logs", logScroll),
+		container.NewTabItem("Logs", logsPage),
 	)
 	tabs.SetTabLocation(container.TabLocationTop)
 
@@ -274,104 +547,130 @@ func main() {
 	window.ShowAndRun()
 }
 
-func startServer() {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/chat/completions", handleMockStream)
-	mux.HandleFunc("/", handleProxy)
+// echoNone is the echo transform option sending the message as is
+const echoNone = "as is"
+
+func echoTransformOption(transform string) string {
+	if transform == "" {
+		return echoNone
+	}
+	return transform
+}
+
+func echoTransformValue(option string) string {
+	if option == echoNone {
+		return ""
+	}
+	return option
+}
+
+func toolCallsToPairs(calls []ToolCall) []ui.Pair {
+	pairs := make([]ui.Pair, 0, len(calls))
+	for _, call := range calls {
+		pairs = append(pairs, ui.Pair{Key: call.Name, Value: call.Arguments})
+	}
+	return pairs
+}
 
+func pairsToToolCalls(pairs []ui.Pair) []ToolCall {
+	calls := make([]ToolCall, 0, len(pairs))
+	for _, p := range pairs {
+		calls = append(calls, ToolCall{Name: p.Key, Arguments: p.Value})
+	}
+	return calls
+}
+
+// startServer binds the configured port and serves in the background.
+// Binding happens synchronously so a busy port is reported to the caller.
+func startServer() error {
 	server = &http.Server{
 		Addr:    ":" + strconv.Itoa(appConfig.Port),
-		Handler: mux,
+		Handler: http.HandlerFunc(handleRequest),
+	}
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
 	}
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			panic(err)
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Fprintf(os.Stderr, "server error: %v\n", err)
 		}
 	}()
+	return nil
 }
 
 func handleMockStream(w http.ResponseWriter, r *http.Request) {
-	if !appConfig.MockEnabled {
-		handleProxy(w, r)
-		return
+	var req chatCompletionRequest
+	body, parseErr := readJSONBody(r, &req)
+	if model := routeParam(r, "model"); model != "" {
+		req.Model = model
 	}
-	mockingFunctions := strings.Split(appConfig.MockFunctions, ",")
-	funcName := r.Header.Get("FunctionName")
 
-	// Check if the function name is in the list of mocking functions
-	shouldMock := false
-	for _, mockFunc := range mockingFunctions {
-		if mockFunc == "*" {
-			shouldMock = true
-			break
-		}
-		if strings.TrimSpace(mockFunc) == funcName {
-			shouldMock = true
-			break
-		}
+	resp, ok := resolveMock(w, r, &mockRequest{
+		API:      apiChatCompletions,
+		Model:    req.Model,
+		Stream:   req.Stream,
+		Messages: req.Messages,
+		Body:     body,
+	}, parseErr)
+	if !ok {
+		return
 	}
 
-	if !shouldMock {
-		handleProxy(w, r)
+	if !req.Stream {
+		writeChatCompletion(w, &req, resp)
 		return
 	}
 
-	rawMode := appConfig.RawMode
-	configMutex.RLock()
-	thinking := appConfig.MockThinking
-	content := appConfig.MockContent
-	configMutex.RUnlock()
+	stream := newChatStream(w, req.responseModel(), resp.RawMode)
+	handleMockStream0(stream, resp.split(resp.Thinking), "reasoning_content", resp.thinkingPace())
+	handleMockStream0(stream, resp.split(resp.Content), "content", resp.contentPace())
+	streamToolCalls(stream, resp.ToolCalls, resp.toolCallsPace())
 
-	summary := fmt.Sprintf("Mocking function: %s", funcName)
-	requestLogger.LogWithRequest(summary, r, fmt.Sprintf("Thinking: %s\nContent: %s\nRawMode: %t", thinking, content, rawMode))
-
-	handleMockStream0(w, thinking, "reasoning_content", rawMode, appConfig.MockThinkingRate)
-	handleMockStream0(w, content, "content", rawMode, appConfig.MockContentRate)
-	fmt.Fprintf(w, "data: %s\n", "[DONE]")
-	w.(http.Flusher).Flush()
+	var usage interface{}
+	if req.StreamOptions.IncludeUsage {
+		usage = req.usage(resp)
+	}
+	stream.finish(resp.finishReason(), usage)
 }
 
-func handleMockStream0(w http.ResponseWriter, content, key string, rawMode bool, rate int) {
-	content = strings.ReplaceAll(content, "⇥", "\t")
-	chunks := strings.SplitAfter(content, "\n")
-
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
-	for _, chunk := range chunks {
-		if chunk == "" {
-			continue
-		}
-		ch := chunk
-		if rawMode {
-			fmt.Fprintf(w, "%s\n", ch)
+func handleMockStream0(stream *chatStream, chunks []string, key string, p pace) {
+	streamChunks(stream.w, chunks, p, func(ch string) {
+		if stream.rawMode {
+			fmt.Fprintf(stream.w, "%s\n", ch)
 		} else {
-			data := map[string]interface{}{
-				"choices": []interface{}{
-					map[string]interface{}{
-						"delta": map[string]string{
-							key: ch,
-						},
-					},
-				},
-			}
-
-			jsonData, _ := json.Marshal(data)
-			fmt.Fprintf(w, "data: %s\n", jsonData)
+			stream.send(map[string]interface{}{key: ch}, nil)
 		}
-
-		w.(http.Flusher).Flush()
-		time.Sleep(time.Duration(rate) * time.Millisecond)
-	}
+	})
 }
 
 func handleProxy(w http.ResponseWriter, r *http.Request) {
+	handleProxyFaults(w, r, nil)
+}
+
+// handleProxyFaults proxies with faults injected into the backend response, nil uses the proxy faults of the config
+func handleProxyFaults(w http.ResponseWriter, r *http.Request, faults []Fault) {
+	start := time.Now()
+	recorder.MarkProxied(r)
 	configMutex.RLock()
 	targetURL := appConfig.BackendURL
+	if faults == nil {
+		faults = appConfig.ProxyFaults
+	}
+	cassettes := appConfig.Cassettes
 	configMutex.RUnlock()
 
+	// Requests of the mocked apis can be recorded to cassettes, handleReplay answers them in replay mode
+	api, _, _ := matchRoute(r.URL.Path)
+	var body []byte
+	var fingerprint string
+	if api != "" && cassettes.Mode == cassetteRecord {
+		body = readBody(r)
+		fingerprint = requestFingerprint(r, body, cassettes.IgnoreFields)
+	}
+
 	if targetURL == "" {
 		http.Error(w, "Proxy URL is not set", http.StatusBadGateway)
 		requestLogger.LogWithRequest("Proxy URL is not set", r, "")
@@ -403,7 +702,7 @@ func handleProxy(w http.ResponseWriter, r *http.Request) {
 	r.Host = target.Host
 
 	// Set streaming headers only for streaming endpoints
-	if r.URL.Path == "/chat/completions" {
+	if api == apiChatCompletions {
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
@@ -413,20 +712,109 @@ func handleProxy(w http.ResponseWriter, r *http.Request) {
 		w.Header().Del("Content-Length")
 	}
 
-	// Proxy the request
-	logEntry := requestLogger.LogWithRequest(fmt.Sprintf("Proxying request: %s", r.URL.String()), r, "")
+	// Proxied responses are streamed as they arrive, each write of the backend counts as a chunk
+	summary := fmt.Sprintf("Proxying request: %s", r.URL.String())
+	if fingerprint != "" {
+		summary += ", recording cassette " + fingerprint
+	}
+	// Proxy the request, handleRequest records the response as received by the client
+	out, ok := proxyFaultWriter(w, r, api, faults, summary)
+	if !ok {
+		return
+	}
+	switch {
+	case fingerprint != "":
+		// The cassette keeps the backend response, without the faults. Errors are not kept.
+		cw := newCassetteWriter(out, r, body, start)
+		proxy.ServeHTTP(cw, r)
+		if cw.cassette.Status < http.StatusBadRequest {
+			if err := saveCassette(cassettes.Dir, fingerprint, &cw.cassette); err != nil {
+				fmt.Fprintf(os.Stderr, "save cassette: %v\n", err)
+			}
+		}
+	default:
+		proxy.ServeHTTP(out, r)
+	}
+}
 
-	// Create a response recorder to capture the response
-	recorder := recorder.NewResponseRecorder(w, logEntry)
-	proxy.ServeHTTP(recorder, r)
+// proxyFaultWriter picks one of faults for a proxied or replayed response and logs the request with summary.
+// It answers http_error and unknown faults itself and reports false,
+// otherwise it returns the writer injecting the fault, if any.
+func proxyFaultWriter(w http.ResponseWriter, r *http.Request, api string, faults []Fault, summary string) (http.ResponseWriter, bool) {
+	fault := pickFault(faults, true)
+	if fault == nil {
+		requestLogger.LogWithRequest(summary, r, "")
+		return w, true
+	}
+	summary += ", fault: " + fault.Type
+	if !slices.Contains(faultTypes, fault.Type) {
+		message := fmt.Sprintf("Unknown proxy fault %q", fault.Type)
+		requestLogger.LogWithRequest(message, r, "")
+		writeAPIError(w, api, http.StatusInternalServerError, message)
+		return nil, false
+	}
+	if fault.Type == faultHTTPError {
+		status, message := mockedError(fault.Status, fault.Message)
+		requestLogger.LogWithRequest(summary, r, message)
+		writeAPIError(w, api, status, message)
+		return nil, false
+	}
+	requestLogger.LogWithRequest(summary, r, "")
+	fw := newFaultWriter(w, r, api)
+	fw.countWrites = true
+	fw.arm(fault, true)
+	return fw, true
+}
+
+const filterAll = "All"
 
-	// Create a response object for logging
-	resp := &http.Response{
-		StatusCode: recorder.Status(),
-		Header:     recorder.Header(),
-		Body:       io.NopCloser(recorder.Body()),
+// newLogFilterBar returns the controls filtering the log list
+func newLogFilterBar() fyne.CanvasObject {
+	var filter recorder.LogFilter
+	apply := func() {
+		requestLogger.SetFilter(filter)
+	}
+	selectValue := func(option string) string {
+		if option == filterAll {
+			return ""
+		}
+		return option
+	}
+	newSelect := func(options []string, set func(value string)) *widget.Select {
+		s := widget.NewSelect(append([]string{filterAll}, options...), nil)
+		s.SetSelected(filterAll)
+		s.OnChanged = func(option string) {
+			set(selectValue(option))
+			apply()
+		}
+		return s
+	}
+	newEntry := func(placeHolder string, set func(value string)) *widget.Entry {
+		e := widget.NewEntry()
+		e.SetPlaceHolder(placeHolder)
+		e.OnChanged = func(text string) {
+			set(strings.TrimSpace(text))
+			apply()
+		}
+		return e
 	}
 
-	// Log the response
-	logEntry.Response = resp
+	statusSelect := newSelect([]string{"2xx", "3xx", "4xx", "5xx"}, func(v string) { filter.StatusClass = v })
+	methodSelect := newSelect([]string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}, func(v string) { filter.Method = v })
+	sourceSelect := newSelect([]string{recorder.SourceMocked, recorder.SourceProxied}, func(v string) { filter.Source = v })
+	pinnedCheck := widget.NewCheck("Pinned", func(checked bool) {
+		filter.Pinned = checked
+		apply()
+	})
+
+	return container.NewVBox(
+		container.NewHBox(widget.NewLabel("Status"), statusSelect, widget.NewLabel("Method"), methodSelect,
+			widget.NewLabel("Source"), sourceSelect, pinnedCheck),
+		container.NewGridWithColumns(4,
+			newEntry("Path", func(v string) { filter.Path = v }),
+			newEntry("FunctionName", func(v string) { filter.FunctionName = v }),
+			newEntry("Model", func(v string) { filter.Model = v }),
+			newEntry("Search bodies and tags", func(v string) { filter.Text = v }),
+		),
+	)
 }
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"mock-stream/chunker"
+	"mock-stream/latency"
+	"mock-stream/util"
+)
+
+// mockResponse is the answer for a mocked request, independent of the API format
+type mockResponse struct {
+	Thinking      string
+	ThinkingRate  int
+	Content       string
+	ContentRate   int
+	ToolCalls     []ToolCall
+	ToolCallsRate int
+	RawMode       bool
+
+	chunks chunker.Chunker
+	timer  *latency.Timer // nil without a latency model
+}
+
+// configAnswer returns the mock answer set in the window or the top level of the config file
+func configAnswer(cfg *Config) MockAnswer {
+	return MockAnswer{
+		Thinking:      cfg.MockThinking,
+		ThinkingRate:  cfg.MockThinkingRate,
+		Content:       cfg.MockContent,
+		ContentRate:   cfg.MockContentRate,
+		ToolCalls:     cfg.MockToolCalls,
+		ToolCallsRate: cfg.MockToolCallsRate,
+		RawMode:       cfg.RawMode,
+		Echo:          cfg.MockEcho,
+		EchoTransform: cfg.MockEchoTransform,
+		Chunking:      cfg.MockChunking,
+		ChunkSize:     cfg.MockChunkSize,
+		ChunkPattern:  cfg.MockChunkPattern,
+	}
+}
+
+// response prepares the answer for streaming, rates left at 0 are taken from cfg.
+// An invalid chunking is reported and replaced by line chunking.
+func (a MockAnswer) response(cfg *Config) (mockResponse, error) {
+	// Rows still being edited in the GUI have no name yet
+	var toolCalls []ToolCall
+	for _, call := range a.ToolCalls {
+		if call.Name != "" {
+			toolCalls = append(toolCalls, call)
+		}
+	}
+
+	orDefault := func(rate, defaultRate int) int {
+		if rate == 0 {
+			return defaultRate
+		}
+		return rate
+	}
+
+	chunking, chunkSize, chunkPattern := a.Chunking, a.ChunkSize, a.ChunkPattern
+	if chunking == "" {
+		chunking, chunkSize, chunkPattern = cfg.MockChunking, cfg.MockChunkSize, cfg.MockChunkPattern
+	}
+	chunks, err := chunker.New(chunking, chunkSize, chunkPattern)
+	if err != nil {
+		chunks, _ = chunker.New(chunker.Line, 0, "")
+	}
+	var timer *latency.Timer
+	model := &cfg.Latency
+	if a.Latency != nil {
+		model = a.Latency
+	}
+	if model.Enabled() {
+		timer = latency.NewTimer(model)
+	}
+
+	return mockResponse{
+		Thinking:      strings.ReplaceAll(a.Thinking, "⇥", "\t"),
+		ThinkingRate:  orDefault(a.ThinkingRate, cfg.MockThinkingRate),
+		Content:       strings.ReplaceAll(a.Content, "⇥", "\t"),
+		ContentRate:   orDefault(a.ContentRate, cfg.MockContentRate),
+		ToolCalls:     toolCalls,
+		ToolCallsRate: orDefault(a.ToolCallsRate, cfg.MockToolCallsRate),
+		RawMode:       a.RawMode,
+		chunks:        chunks,
+		timer:         timer,
+	}, err
+}
+
+// mockRequest is what the rules see of a request, independent of the API format
+type mockRequest struct {
+	API      string
+	Model    string
+	Stream   bool
+	Messages []chatMessage
+	Body     []byte
+
+	decoded     bool
+	decodedJSON interface{}
+}
+
+// decodedBody returns the JSON body decoded into generic values, nil if it is not JSON
+func (r *mockRequest) decodedBody() interface{} {
+	if !r.decoded {
+		json.Unmarshal(r.Body, &r.decodedJSON)
+		r.decoded = true
+	}
+	return r.decodedJSON
+}
+
+// lastUserMessage returns the text of the last message sent by the user
+func (r *mockRequest) lastUserMessage() string {
+	for i := len(r.Messages) - 1; i >= 0; i-- {
+		if r.Messages[i].Role == "user" {
+			return r.Messages[i].Text()
+		}
+	}
+	return ""
+}
+
+// toolNames returns the names of the tools offered in the request.
+// OpenAI, Anthropic, Ollama and Gemini each nest them differently.
+func (r *mockRequest) toolNames() []string {
+	body, _ := r.decodedBody().(map[string]interface{})
+	tools, _ := body["tools"].([]interface{})
+
+	var names []string
+	addName := func(v interface{}) {
+		if obj, ok := v.(map[string]interface{}); ok {
+			if name, ok := obj["name"].(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	for _, tool := range tools {
+		obj, _ := tool.(map[string]interface{})
+		addName(obj)
+		addName(obj["function"])
+		for _, key := range []string{"functionDeclarations", "function_declarations"} {
+			declarations, _ := obj[key].([]interface{})
+			for _, declaration := range declarations {
+				addName(declaration)
+			}
+		}
+	}
+	return names
+}
+
+// Echo transforms
+const (
+	echoReverse = "reverse"
+	echoUpper   = "upper"
+	echoLower   = "lower"
+)
+
+var echoTransforms = []string{echoReverse, echoUpper, echoLower}
+
+// echoText applies an echo transform to text, unknown transforms leave it unchanged
+func echoText(text, transform string) string {
+	switch transform {
+	case echoReverse:
+		runes := []rune(text)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return string(runes)
+	case echoUpper:
+		return strings.ToUpper(text)
+	case echoLower:
+		return strings.ToLower(text)
+	}
+	return text
+}
+
+// textMessage builds a chat message with plain text content
+func textMessage(role, text string) chatMessage {
+	content, _ := json.Marshal(text)
+	return chatMessage{Role: role, Content: content}
+}
+
+// completionTokens estimates the tokens generated for the response
+func (resp mockResponse) completionTokens() int {
+	tokens := util.EstimateTokens(resp.Thinking) + util.EstimateTokens(resp.Content)
+	for _, call := range resp.ToolCalls {
+		tokens += util.EstimateTokens(call.Name + call.Arguments)
+	}
+	return tokens
+}
+
+// promptTokens estimates the tokens of the request messages
+func promptTokens(messages []chatMessage) int {
+	tokens := 0
+	for _, m := range messages {
+		tokens += util.EstimateTokens(m.Text())
+	}
+	return tokens
+}
+
+// split splits thinking or content into the chunks that are streamed one by one
+func (resp mockResponse) split(text string) []string {
+	return resp.chunks(text)
+}
+
+// pace times the chunks of one part of the answer
+type pace struct {
+	rate      int // ms after each chunk, or before it with a latency model
+	reasoning bool
+	timer     *latency.Timer
+}
+
+func (resp mockResponse) thinkingPace() pace {
+	return pace{rate: resp.ThinkingRate, reasoning: true, timer: resp.timer}
+}
+
+func (resp mockResponse) contentPace() pace {
+	return pace{rate: resp.ContentRate, timer: resp.timer}
+}
+
+func (resp mockResponse) toolCallsPace() pace {
+	return pace{rate: resp.ToolCallsRate, timer: resp.timer}
+}
+
+// delay returns the wait of the latency model before chunk
+func (p pace) delay(chunk string) time.Duration {
+	return p.timer.Delay(util.EstimateTokens(chunk), time.Duration(p.rate)*time.Millisecond, p.reasoning)
+}
+
+// generationTime returns how long streaming the answer would take with the latency model,
+// answers sent at once wait that long
+func (resp mockResponse) generationTime() time.Duration {
+	var total time.Duration
+	add := func(chunks []string, p pace) {
+		for _, chunk := range chunks {
+			if chunk != "" {
+				total += p.delay(chunk)
+			}
+		}
+	}
+	add(resp.split(resp.Thinking), resp.thinkingPace())
+	add(resp.split(resp.Content), resp.contentPace())
+	for _, call := range resp.ToolCalls {
+		add(append([]string{call.Name}, util.SplitRunes(call.Arguments, toolArgumentsChunkSize)...), resp.toolCallsPace())
+	}
+	return total
+}
+
+// streamChunks calls emit for every chunk and flushes it. Without a latency model
+// it waits rate ms after each chunk, otherwise the model decides the wait before each one.
+func streamChunks(w http.ResponseWriter, chunks []string, p pace, emit func(chunk string)) {
+	fw, _ := w.(*faultWriter)
+	for _, chunk := range chunks {
+		if chunk == "" {
+			continue
+		}
+		if fw != nil && fw.ended {
+			return
+		}
+		if p.timer != nil {
+			// Headers and events written so far go out before the wait
+			w.(http.Flusher).Flush()
+			time.Sleep(p.delay(chunk))
+		}
+		emit(chunk)
+		w.(http.Flusher).Flush()
+		if fw != nil {
+			fw.chunkSent()
+		}
+		if p.timer == nil {
+			time.Sleep(time.Duration(p.rate) * time.Millisecond)
+		}
+	}
+}
+
+// argumentsObject returns the call arguments as a JSON object, for APIs that do not send them as a string
+func (call ToolCall) argumentsObject() json.RawMessage {
+	if json.Valid([]byte(call.Arguments)) && strings.HasPrefix(strings.TrimSpace(call.Arguments), "{") {
+		return json.RawMessage(call.Arguments)
+	}
+	return json.RawMessage("{}")
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"mock-stream/util"
+)
+
+type ollamaRequest struct {
+	Model    string        `json:"model"`
+	Stream   *bool         `json:"stream"` // Ollama streams unless stream is false
+	Messages []chatMessage `json:"messages"`
+	Prompt   string        `json:"prompt"`
+}
+
+// handleOllamaChat mocks Ollama /api/chat
+func handleOllamaChat(w http.ResponseWriter, r *http.Request) {
+	handleOllama(w, r, true)
+}
+
+// handleOllamaGenerate mocks Ollama /api/generate
+func handleOllamaGenerate(w http.ResponseWriter, r *http.Request) {
+	handleOllama(w, r, false)
+}
+
+func handleOllama(w http.ResponseWriter, r *http.Request, chat bool) {
+	start := time.Now()
+	var req ollamaRequest
+	body, parseErr := readJSONBody(r, &req)
+	if model := routeParam(r, "model"); model != "" {
+		req.Model = model
+	}
+	stream := req.Stream == nil || *req.Stream
+
+	api, messages := apiOllamaChat, req.Messages
+	if !chat {
+		api, messages = apiOllamaGenerate, []chatMessage{textMessage("user", req.Prompt)}
+	}
+	resp, ok := resolveMock(w, r, &mockRequest{
+		API:      api,
+		Model:    req.Model,
+		Stream:   stream,
+		Messages: messages,
+		Body:     body,
+	}, parseErr)
+	if !ok {
+		return
+	}
+
+	o := &ollamaStream{w: w, chat: chat, model: req.Model, rawMode: resp.RawMode}
+	if !stream {
+		o.writeResponse(&req, resp, start)
+		return
+	}
+	o.streamResponse(&req, resp, start)
+}
+
+// writeOllamaError writes an error in the Ollama error format
+func writeOllamaError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ollamaErrorBody(message))
+}
+
+func ollamaErrorBody(message string) map[string]interface{} {
+	return map[string]interface{}{"error": message}
+}
+
+// ollamaStream writes newline delimited JSON objects for /api/chat or /api/generate
+type ollamaStream struct {
+	w       http.ResponseWriter
+	chat    bool
+	model   string
+	rawMode bool
+}
+
+// object builds a response with the fields of a chat message or a generate response
+func (o *ollamaStream) object(thinking, content string, toolCalls []ToolCall, done bool) map[string]interface{} {
+	obj := map[string]interface{}{
+		"model":      o.model,
+		"created_at": time.Now().UTC().Format(time.RFC3339Nano),
+		"done":       done,
+	}
+	if o.chat {
+		message := map[string]interface{}{
+			"role":    "assistant",
+			"content": content,
+		}
+		if thinking != "" {
+			message["thinking"] = thinking
+		}
+		if len(toolCalls) > 0 {
+			calls := make([]interface{}, 0, len(toolCalls))
+			for _, call := range toolCalls {
+				calls = append(calls, map[string]interface{}{
+					"function": map[string]interface{}{
+						"name":      call.Name,
+						"arguments": call.argumentsObject(),
+					},
+				})
+			}
+			message["tool_calls"] = calls
+		}
+		obj["message"] = message
+	} else {
+		obj["response"] = content
+		if thinking != "" {
+			obj["thinking"] = thinking
+		}
+	}
+	return obj
+}
+
+// addStats adds the final counters. The mock has no prompt evaluation, it is reported as instant.
+func (o *ollamaStream) addStats(obj map[string]interface{}, req *ollamaRequest, resp mockResponse, start, evalStart time.Time) {
+	promptEvalCount := util.EstimateTokens(req.Prompt) + promptTokens(req.Messages)
+	obj["done_reason"] = "stop"
+	obj["total_duration"] = time.Since(start).Nanoseconds()
+	obj["load_duration"] = evalStart.Sub(start).Nanoseconds()
+	obj["prompt_eval_count"] = promptEvalCount
+	obj["prompt_eval_duration"] = 0
+	obj["eval_count"] = resp.completionTokens()
+	obj["eval_duration"] = time.Since(evalStart).Nanoseconds()
+}
+
+func (o *ollamaStream) send(obj map[string]interface{}) {
+	jsonData, _ := json.Marshal(obj)
+	fmt.Fprintf(o.w, "%s\n", jsonData)
+}
+
+func (o *ollamaStream) writeResponse(req *ollamaRequest, resp mockResponse, start time.Time) {
+	if o.rawMode {
+		o.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(o.w, resp.Thinking+resp.Content)
+		return
+	}
+
+	obj := o.object(resp.Thinking, resp.Content, resp.ToolCalls, true)
+	o.addStats(obj, req, resp, start, start)
+	o.w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	o.send(obj)
+}
+
+func (o *ollamaStream) streamResponse(req *ollamaRequest, resp mockResponse, start time.Time) {
+	o.w.Header().Set("Content-Type", "application/x-ndjson")
+	evalStart := time.Now()
+
+	emit := func(build func(ch string) map[string]interface{}) func(string) {
+		return func(ch string) {
+			if o.rawMode {
+				fmt.Fprintf(o.w, "%s\n", ch)
+				return
+			}
+			o.send(build(ch))
+		}
+	}
+	streamChunks(o.w, resp.split(resp.Thinking), resp.thinkingPace(), emit(func(ch string) map[string]interface{} {
+		return o.object(ch, "", nil, false)
+	}))
+	streamChunks(o.w, resp.split(resp.Content), resp.contentPace(), emit(func(ch string) map[string]interface{} {
+		return o.object("", ch, nil, false)
+	}))
+	// Ollama sends tool calls whole, and only from /api/chat
+	if o.chat {
+		for _, call := range resp.ToolCalls {
+			streamChunks(o.w, []string{call.Name}, resp.toolCallsPace(), emit(func(string) map[string]interface{} {
+				return o.object("", "", []ToolCall{call}, false)
+			}))
+		}
+	}
+
+	if !o.rawMode {
+		obj := o.object("", "", nil, true)
+		o.addStats(obj, req, resp, start, evalStart)
+		o.send(obj)
+	}
+	o.w.(http.Flusher).Flush()
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"mock-stream/util"
+)
+
+type chatCompletionRequest struct {
+	Model         string `json:"model"`
+	Stream        bool   `json:"stream"`
+	StreamOptions struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+	Messages []chatMessage `json:"messages"`
+}
+
+// responseModel echoes the requested model, clients often check it
+func (r *chatCompletionRequest) responseModel() string {
+	if r.Model == "" {
+		return "mock-stream"
+	}
+	return r.Model
+}
+
+// usage estimates the token usage of answering the request with resp
+func (r *chatCompletionRequest) usage(resp mockResponse) map[string]interface{} {
+	promptTokens := promptTokens(r.Messages)
+	completionTokens := resp.completionTokens()
+	return map[string]interface{}{
+		"prompt_tokens":     promptTokens,
+		"completion_tokens": completionTokens,
+		"total_tokens":      promptTokens + completionTokens,
+		"completion_tokens_details": map[string]int{
+			"reasoning_tokens": util.EstimateTokens(resp.Thinking),
+		},
+	}
+}
+
+// finishReason is "tool_calls" when resp calls functions
+func (resp mockResponse) finishReason() string {
+	if len(resp.ToolCalls) > 0 {
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+type chatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// Text returns the message content, joining the text parts of multimodal content
+func (m chatMessage) Text() string {
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return ""
+	}
+	var sb strings.Builder
+	for _, part := range parts {
+		if part.Type == "text" || part.Type == "input_text" {
+			sb.WriteString(part.Text)
+		}
+	}
+	return sb.String()
+}
+
+// readJSONBody decodes the request body into v and restores it, so the request can still be proxied.
+// An empty body leaves v untouched.
+func readJSONBody(r *http.Request, v interface{}) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return body, err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return body, nil
+	}
+	return body, json.Unmarshal(body, v)
+}
+
+// writeOpenAIError writes an error in the OpenAI error format
+func writeOpenAIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(openAIErrorBody(status, message))
+}
+
+func openAIErrorBody(status int, message string) map[string]interface{} {
+	errType, code := "invalid_request_error", interface{}(nil)
+	switch {
+	case status == http.StatusTooManyRequests:
+		errType, code = "requests", "rate_limit_exceeded"
+	case status >= 500:
+		errType = "server_error"
+	}
+
+	return map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    errType,
+			"param":   nil,
+			"code":    code,
+		},
+	}
+}
+
+// writeChatCompletion writes the whole mock answer as a single chat.completion object
+func writeChatCompletion(w http.ResponseWriter, req *chatCompletionRequest, resp mockResponse) {
+	if resp.RawMode {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, resp.Thinking+resp.Content)
+		return
+	}
+
+	message := map[string]interface{}{
+		"role":    "assistant",
+		"content": resp.Content,
+	}
+	if resp.Thinking != "" {
+		message["reasoning_content"] = resp.Thinking
+	}
+	if len(resp.ToolCalls) > 0 {
+		toolCalls := make([]interface{}, 0, len(resp.ToolCalls))
+		for _, call := range resp.ToolCalls {
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"id":   util.RandomID("call_", 24),
+				"type": "function",
+				"function": map[string]string{
+					"name":      call.Name,
+					"arguments": call.Arguments,
+				},
+			})
+		}
+		message["tool_calls"] = toolCalls
+		if resp.Content == "" {
+			message["content"] = nil
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      util.RandomID("chatcmpl-", 24),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   req.responseModel(),
+		"choices": []interface{}{
+			map[string]interface{}{
+				"index":         0,
+				"message":       message,
+				"finish_reason": resp.finishReason(),
+			},
+		},
+		"usage": req.usage(resp),
+	})
+}
+
+// chatStream writes chat.completion.chunk events which share one id, creation time and model
+type chatStream struct {
+	w        http.ResponseWriter
+	id       string
+	created  int64
+	model    string
+	rawMode  bool
+	roleSent bool
+}
+
+func newChatStream(w http.ResponseWriter, model string, rawMode bool) *chatStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	return &chatStream{
+		w:       w,
+		id:      util.RandomID("chatcmpl-", 24),
+		created: time.Now().Unix(),
+		model:   model,
+		rawMode: rawMode,
+	}
+}
+
+// send writes one chunk. The first delta of the stream carries the assistant role.
+func (s *chatStream) send(delta map[string]interface{}, finishReason interface{}) {
+	if !s.roleSent {
+		delta["role"] = "assistant"
+		s.roleSent = true
+	}
+	s.sendChunk([]interface{}{
+		map[string]interface{}{
+			"index":         0,
+			"delta":         delta,
+			"logprobs":      nil,
+			"finish_reason": finishReason,
+		},
+	}, nil)
+}
+
+func (s *chatStream) sendChunk(choices []interface{}, usage interface{}) {
+	data := map[string]interface{}{
+		"id":      s.id,
+		"object":  "chat.completion.chunk",
+		"created": s.created,
+		"model":   s.model,
+		"choices": choices,
+	}
+	if usage != nil {
+		data["usage"] = usage
+	}
+	jsonData, _ := json.Marshal(data)
+	fmt.Fprintf(s.w, "data: %s\n\n", jsonData)
+}
+
+// finish writes the chunk carrying finishReason, the optional usage chunk and the [DONE] event
+func (s *chatStream) finish(finishReason string, usage interface{}) {
+	if !s.rawMode {
+		if !s.roleSent {
+			s.send(map[string]interface{}{"content": ""}, nil)
+		}
+		s.send(map[string]interface{}{}, finishReason)
+		if usage != nil {
+			s.sendChunk([]interface{}{}, usage)
+		}
+	}
+	fmt.Fprintf(s.w, "data: %s\n\n", "[DONE]")
+	s.w.(http.Flusher).Flush()
+}
+
+// toolArgumentsChunkSize is the number of characters of function arguments sent per chunk
+const toolArgumentsChunkSize = 8
+
+// streamToolCalls sends each call with its id and name first, followed by the arguments in fragments
+func streamToolCalls(stream *chatStream, calls []ToolCall, p pace) {
+	for i, call := range calls {
+		id := util.RandomID("call_", 24)
+		chunks := append([]string{call.Name}, util.SplitRunes(call.Arguments, toolArgumentsChunkSize)...)
+		first := true
+
+		streamChunks(stream.w, chunks, p, func(ch string) {
+			if stream.rawMode {
+				fmt.Fprintf(stream.w, "%s\n", ch)
+				return
+			}
+			toolCall := map[string]interface{}{"index": i}
+			if first {
+				toolCall["id"] = id
+				toolCall["type"] = "function"
+				toolCall["function"] = map[string]string{"name": ch, "arguments": ""}
+				first = false
+			} else {
+				toolCall["function"] = map[string]string{"arguments": ch}
+			}
+			stream.send(map[string]interface{}{"tool_calls": []interface{}{toolCall}}, nil)
+		})
+	}
+}
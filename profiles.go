@@ -0,0 +1,46 @@
+package main
+
+func findProfile(profiles []Profile, name string) (Profile, bool) {
+	for _, p := range profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+func profileNames(profiles []Profile) []string {
+	names := make([]string, 0, len(profiles))
+	for _, p := range profiles {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// saveProfile returns profiles with p replacing the profile of the same name, or appended
+func saveProfile(profiles []Profile, p Profile) []Profile {
+	saved := make([]Profile, 0, len(profiles)+1)
+	replaced := false
+	for _, existing := range profiles {
+		if existing.Name == p.Name {
+			existing = p
+			replaced = true
+		}
+		saved = append(saved, existing)
+	}
+	if !replaced {
+		saved = append(saved, p)
+	}
+	return saved
+}
+
+// deleteProfile returns profiles without the profile called name
+func deleteProfile(profiles []Profile, name string) []Profile {
+	kept := make([]Profile, 0, len(profiles))
+	for _, p := range profiles {
+		if p.Name != name {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limit keys
+const (
+	rateLimitByAPIKey = "api_key" // every API key has its own budget
+	rateLimitByRule   = "rule"    // all requests of a rule share a budget
+)
+
+type rateBucket struct {
+	requests float64
+	tokens   float64
+	updated  time.Time
+}
+
+var (
+	rateLimitMutex sync.Mutex
+	rateBuckets    = map[string]*rateBucket{}
+)
+
+// rateLimitState is the budget left after a request, retryAfter is set when the request is rejected
+type rateLimitState struct {
+	limit             *RateLimit
+	remainingRequests int
+	remainingTokens   int
+	resetRequests     time.Duration
+	resetTokens       time.Duration
+	retryAfter        time.Duration
+}
+
+// requestAPIKey returns the API key of a request in any of the ways the mocked apis accept it
+func requestAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	for _, name := range []string{"X-Api-Key", "Api-Key", "X-Goog-Api-Key"} {
+		if key := r.Header.Get(name); key != "" {
+			return key
+		}
+	}
+	return r.URL.Query().Get("key")
+}
+
+// rateLimitBucket names the budget of a request, scope separates the limits of different rules
+func rateLimitBucket(limit *RateLimit, scope, ruleID string, r *http.Request) string {
+	if limit.Key == rateLimitByRule {
+		return scope + "\x00" + ruleID
+	}
+	return scope + "\x00" + requestAPIKey(r)
+}
+
+// refill adds the budget regained since the last update, budgets refill evenly over a minute
+func (b *rateBucket) refill(limit *RateLimit, now time.Time) {
+	elapsed := now.Sub(b.updated).Minutes()
+	b.requests = math.Min(b.requests+elapsed*float64(limit.RequestsPerMinute), float64(limit.RequestsPerMinute))
+	b.tokens = math.Min(b.tokens+elapsed*float64(limit.TokensPerMinute), float64(limit.TokensPerMinute))
+	b.updated = now
+}
+
+// takeRateLimit takes one request and tokens from the budget of key, unless that exceeds it.
+// Limits of 0 are not enforced.
+func takeRateLimit(limit *RateLimit, key string, tokens int) rateLimitState {
+	rateLimitMutex.Lock()
+	defer rateLimitMutex.Unlock()
+
+	now := time.Now()
+	b, ok := rateBuckets[key]
+	if !ok {
+		b = &rateBucket{requests: float64(limit.RequestsPerMinute), tokens: float64(limit.TokensPerMinute), updated: now}
+		rateBuckets[key] = b
+	}
+	b.refill(limit, now)
+
+	// A request larger than the whole budget waits for the full budget
+	need := math.Min(float64(tokens), float64(limit.TokensPerMinute))
+	var retryAfter time.Duration
+	if limit.RequestsPerMinute > 0 && b.requests < 1 {
+		retryAfter = refillTime(1-b.requests, limit.RequestsPerMinute)
+	}
+	if limit.TokensPerMinute > 0 && b.tokens < need {
+		retryAfter = max(retryAfter, refillTime(need-b.tokens, limit.TokensPerMinute))
+	}
+	if retryAfter == 0 {
+		if limit.RequestsPerMinute > 0 {
+			b.requests--
+		}
+		if limit.TokensPerMinute > 0 {
+			b.tokens -= need
+		}
+	}
+	return b.state(limit, retryAfter)
+}
+
+// chargeRateLimit takes tokens known only after the request was accepted, the budget may go below 0
+func chargeRateLimit(limit *RateLimit, key string, tokens int) rateLimitState {
+	rateLimitMutex.Lock()
+	defer rateLimitMutex.Unlock()
+
+	b := rateBuckets[key]
+	b.refill(limit, time.Now())
+	if limit.TokensPerMinute > 0 {
+		b.tokens -= float64(tokens)
+	}
+	return b.state(limit, 0)
+}
+
+func (b *rateBucket) state(limit *RateLimit, retryAfter time.Duration) rateLimitState {
+	return rateLimitState{
+		limit:             limit,
+		remainingRequests: max(int(b.requests), 0),
+		remainingTokens:   max(int(b.tokens), 0),
+		resetRequests:     refillTime(float64(limit.RequestsPerMinute)-b.requests, limit.RequestsPerMinute),
+		resetTokens:       refillTime(float64(limit.TokensPerMinute)-b.tokens, limit.TokensPerMinute),
+		retryAfter:        retryAfter,
+	}
+}
+
+// refillTime returns how long regaining amount of a budget of perMinute takes
+func refillTime(amount float64, perMinute int) time.Duration {
+	if amount <= 0 || perMinute <= 0 {
+		return 0
+	}
+	return time.Duration(amount / float64(perMinute) * float64(time.Minute)).Round(time.Millisecond)
+}
+
+// setRateLimitHeaders reports the budget in the headers of the api, Anthropic or else OpenAI style
+func setRateLimitHeaders(w http.ResponseWriter, api string, s rateLimitState) {
+	h := w.Header()
+	if s.retryAfter > 0 {
+		h.Set("Retry-After", strconv.Itoa(int(math.Ceil(s.retryAfter.Seconds()))))
+	}
+
+	type budget struct {
+		name      string
+		limit     int
+		remaining int
+		reset     time.Duration
+	}
+	for _, b := range []budget{
+		{"requests", s.limit.RequestsPerMinute, s.remainingRequests, s.resetRequests},
+		{"tokens", s.limit.TokensPerMinute, s.remainingTokens, s.resetTokens},
+	} {
+		if b.limit <= 0 {
+			continue
+		}
+		if api == apiAnthropicMessage {
+			h.Set("anthropic-ratelimit-"+b.name+"-limit", strconv.Itoa(b.limit))
+			h.Set("anthropic-ratelimit-"+b.name+"-remaining", strconv.Itoa(b.remaining))
+			h.Set("anthropic-ratelimit-"+b.name+"-reset", time.Now().Add(b.reset).UTC().Format(time.RFC3339))
+		} else {
+			h.Set("x-ratelimit-limit-"+b.name, strconv.Itoa(b.limit))
+			h.Set("x-ratelimit-remaining-"+b.name, strconv.Itoa(b.remaining))
+			h.Set("x-ratelimit-reset-"+b.name, b.reset.String())
+		}
+	}
+}
+
+// rateLimitMessage explains a rejected request like the OpenAI API does
+func rateLimitMessage(s rateLimitState) string {
+	budget := "requests per minute"
+	if s.limit.RequestsPerMinute == 0 || s.remainingRequests > 0 {
+		budget = "tokens per minute"
+	}
+	return fmt.Sprintf("Rate limit reached for %s. Please try again in %s.", budget, s.retryAfter)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func resetRateBuckets(t *testing.T) {
+	t.Helper()
+	rateLimitMutex.Lock()
+	rateBuckets = map[string]*rateBucket{}
+	rateLimitMutex.Unlock()
+	t.Cleanup(func() {
+		rateLimitMutex.Lock()
+		rateBuckets = map[string]*rateBucket{}
+		rateLimitMutex.Unlock()
+	})
+}
+
+func TestTakeRateLimit(t *testing.T) {
+	type take struct {
+		tokens    int
+		accepted  bool
+		requests  int // remaining requests
+		remaining int // remaining tokens
+	}
+	tests := []struct {
+		name  string
+		limit RateLimit
+		takes []take
+	}{
+		{"requests", RateLimit{RequestsPerMinute: 2}, []take{
+			{0, true, 1, 0},
+			{0, true, 0, 0},
+			{0, false, 0, 0},
+		}},
+		{"tokens", RateLimit{TokensPerMinute: 100}, []take{
+			{60, true, 0, 40},
+			{50, false, 0, 40},
+			{40, true, 0, 0},
+		}},
+		{"both", RateLimit{RequestsPerMinute: 5, TokensPerMinute: 100}, []take{
+			{30, true, 4, 70},
+			{80, false, 4, 70},
+			{70, true, 3, 0},
+		}},
+		{"larger than the budget", RateLimit{TokensPerMinute: 100}, []take{
+			{500, true, 0, 0},
+			{1, false, 0, 0},
+		}},
+		{"unlimited", RateLimit{}, []take{
+			{1000, true, 0, 0},
+			{1000, true, 0, 0},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetRateBuckets(t)
+			for i, take := range tt.takes {
+				s := takeRateLimit(&tt.limit, "key", take.tokens)
+				if accepted := s.retryAfter == 0; accepted != take.accepted {
+					t.Errorf("take %d of %d tokens: accepted %v, want %v", i+1, take.tokens, accepted, take.accepted)
+				}
+				if s.remainingRequests != take.requests || s.remainingTokens != take.remaining {
+					t.Errorf("take %d: remaining %d requests and %d tokens, want %d and %d",
+						i+1, s.remainingRequests, s.remainingTokens, take.requests, take.remaining)
+				}
+			}
+		})
+	}
+}
+
+func TestTakeRateLimitRetryAfter(t *testing.T) {
+	resetRateBuckets(t)
+	limit := &RateLimit{RequestsPerMinute: 60, TokensPerMinute: 600}
+
+	takeRateLimit(limit, "key", 590)
+	// 10 tokens are left, 100 more take 10 seconds to refill
+	s := takeRateLimit(limit, "key", 110)
+	if s.retryAfter < 9900*time.Millisecond || s.retryAfter > 10*time.Second {
+		t.Errorf("retry after %s, want about 10s", s.retryAfter)
+	}
+	if s.resetTokens < 58*time.Second || s.resetTokens > 59*time.Second {
+		t.Errorf("tokens reset in %s, want about 59s", s.resetTokens)
+	}
+}
+
+func TestRateLimitBuckets(t *testing.T) {
+	resetRateBuckets(t)
+	limit := &RateLimit{RequestsPerMinute: 1}
+
+	if s := takeRateLimit(limit, "a", 0); s.retryAfter != 0 {
+		t.Fatal("first request of a rejected")
+	}
+	if s := takeRateLimit(limit, "b", 0); s.retryAfter != 0 {
+		t.Error("first request of b rejected, buckets must not share a budget")
+	}
+	if s := takeRateLimit(limit, "a", 0); s.retryAfter == 0 {
+		t.Error("second request of a accepted")
+	}
+}
+
+func TestChargeRateLimit(t *testing.T) {
+	resetRateBuckets(t)
+	limit := &RateLimit{RequestsPerMinute: 10, TokensPerMinute: 100}
+
+	takeRateLimit(limit, "key", 20)
+	s := chargeRateLimit(limit, "key", 150)
+	if s.retryAfter != 0 || s.remainingTokens != 0 || s.remainingRequests != 9 {
+		t.Errorf("charge: retry after %s, remaining %d requests and %d tokens, want 0s, 9 and 0",
+			s.retryAfter, s.remainingRequests, s.remainingTokens)
+	}
+	// The budget went 70 tokens below 0, so even 10 tokens wait for 80
+	s = takeRateLimit(limit, "key", 10)
+	if s.retryAfter < 47*time.Second || s.retryAfter > 48*time.Second {
+		t.Errorf("retry after %s, want about 48s", s.retryAfter)
+	}
+
+	unlimited := &RateLimit{RequestsPerMinute: 10}
+	takeRateLimit(unlimited, "requests only", 0)
+	if s := chargeRateLimit(unlimited, "requests only", 1000); s.remainingRequests != 9 || s.remainingTokens != 0 {
+		t.Errorf("charge without a token limit: remaining %d requests and %d tokens", s.remainingRequests, s.remainingTokens)
+	}
+}
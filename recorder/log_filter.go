@@ -0,0 +1,112 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// modelPattern finds the model in bodies that are not valid JSON, like truncated ones
+var modelPattern = regexp.MustCompile(`"model"\s*:\s*"([^"]*)"`)
+
+// Sources of log entries
+const (
+	SourceMocked  = "mocked"
+	SourceProxied = "proxied"
+)
+
+// LogFilter selects the entries shown in the log list, empty fields match every entry
+type LogFilter struct {
+	StatusClass  string // 2xx, 3xx, 4xx or 5xx
+	Method       string
+	Path         string // part of the path
+	Source       string // SourceMocked or SourceProxied
+	FunctionName string // part of the FunctionName header
+	Model        string // part of the requested model
+	Text         string // part of the summary, the bodies or the tags, in any case
+	Pinned       bool   // only pinned entries
+}
+
+func (f LogFilter) match(log *RequestLogEntry) bool {
+	if f.Pinned && !log.Pinned {
+		return false
+	}
+	if f.StatusClass != "" && (log.Response == nil || fmt.Sprintf("%dxx", log.Response.StatusCode/100) != f.StatusClass) {
+		return false
+	}
+	if f.Source != "" && log.Source() != f.Source {
+		return false
+	}
+	if f.Method != "" || f.Path != "" || f.FunctionName != "" {
+		if log.Request == nil {
+			return false
+		}
+		if f.Method != "" && log.Request.Method != f.Method {
+			return false
+		}
+		if !strings.Contains(log.Request.URL.Path, f.Path) {
+			return false
+		}
+		if !containsFold(log.Request.Header.Get("FunctionName"), f.FunctionName) {
+			return false
+		}
+	}
+	if !containsFold(log.model, f.Model) {
+		return false
+	}
+	if f.Text != "" {
+		text := strings.ToLower(f.Text)
+		found := strings.Contains(strings.ToLower(log.Summary), text) ||
+			bytes.Contains(bytes.ToLower(log.requestBody), []byte(text)) ||
+			bytes.Contains(bytes.ToLower(log.responseBody), []byte(text)) ||
+			containsFold(strings.Join(log.Tags, " "), text)
+		if log.body != nil {
+			found = found || bytes.Contains(bytes.ToLower(log.body.Bytes()), []byte(text))
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// requestModel returns the model of a request, from the body or from a path like
+// /v1beta/models/{model}:method or the Azure /openai/deployments/{deployment}/...
+func requestModel(body []byte, path string) string {
+	var req struct {
+		Model string `json:"model"`
+	}
+	if json.Unmarshal(body, &req) == nil && req.Model != "" {
+		return req.Model
+	}
+	if m := modelPattern.FindSubmatch(body); m != nil {
+		return string(m[1])
+	}
+	if _, model, ok := strings.Cut(path, "/models/"); ok {
+		model, _, _ = strings.Cut(model, ":")
+		model, _, _ = strings.Cut(model, "/")
+		return model
+	}
+	if _, deployment, ok := strings.Cut(path, "/openai/deployments/"); ok {
+		deployment, _, _ = strings.Cut(deployment, "/")
+		return deployment
+	}
+	return ""
+}
+
+// ParseTags splits comma separated tags
+func ParseTags(text string) []string {
+	var tags []string
+	for _, tag := range strings.Split(text, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
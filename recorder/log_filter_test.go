@@ -0,0 +1,27 @@
+package recorder
+
+import "testing"
+
+func TestRequestModel(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		path  string
+		model string
+	}{
+		{"body", `{"model":"gpt-4o","stream":true}`, "/v1/chat/completions", "gpt-4o"},
+		{"body wins over path", `{"model":"gpt-4o"}`, "/openai/deployments/my-gpt/chat/completions", "gpt-4o"},
+		{"truncated body", `{"model": "claude-sonnet", "messages": [{"role":`, "/v1/messages", "claude-sonnet"},
+		{"gemini path", ``, "/v1beta/models/gemini-2.0-flash:streamGenerateContent", "gemini-2.0-flash"},
+		{"azure deployment", `{"messages":[]}`, "/openai/deployments/my-gpt/chat/completions", "my-gpt"},
+		{"azure responses", ``, "/openai/deployments/my-gpt/responses", "my-gpt"},
+		{"none", `{"prompt":"hi"}`, "/api/generate", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestModel([]byte(tt.body), tt.path); got != tt.model {
+				t.Errorf("requestModel = %q, want %q", got, tt.model)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// storedEntry is the JSON line of a finished log entry
+type storedEntry struct {
+	Time          time.Time   `json:"time"`
+	Summary       string      `json:"summary"`
+	Source        string      `json:"source,omitempty"`
+	Details       string      `json:"details,omitempty"`
+	Method        string      `json:"method,omitempty"`
+	URL           string      `json:"url,omitempty"`
+	RequestHeader http.Header `json:"request_header,omitempty"`
+	RequestBody   string      `json:"request_body,omitempty"`
+	// RequestBodyTruncated is set when the body was longer than the log keeps
+	RequestBodyTruncated  bool          `json:"request_body_truncated,omitempty"`
+	Status                int           `json:"status,omitempty"`
+	ResponseHeader        http.Header   `json:"response_header,omitempty"`
+	ResponseBody          string        `json:"response_body,omitempty"`
+	ResponseBodyTruncated bool          `json:"response_body_truncated,omitempty"`
+	TTFBMs                float64       `json:"ttfb_ms"`
+	DurationMs            float64       `json:"duration_ms"`
+	Chunks                []storedChunk `json:"chunks,omitempty"`
+	Pinned                bool          `json:"pinned,omitempty"`
+	Tags                  []string      `json:"tags,omitempty"`
+}
+
+// secretHeaders are masked in the log file
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "X-Api-Key", "X-Goog-Api-Key", "Api-Key", "Cookie", "Set-Cookie"}
+
+// redactHeader returns a copy of header with the values of secretHeaders masked.
+// The scheme of an authorization, like Bearer, is kept.
+func redactHeader(header http.Header) http.Header {
+	if header == nil {
+		return nil
+	}
+	header = header.Clone()
+	for _, name := range secretHeaders {
+		values := header.Values(name)
+		for i, value := range values {
+			if scheme, _, ok := strings.Cut(value, " "); ok && strings.HasSuffix(name, "Authorization") {
+				values[i] = scheme + " " + redacted
+			} else {
+				values[i] = redacted
+			}
+		}
+	}
+	return header
+}
+
+// redactURL masks the key query parameter, used by Gemini for the API key
+func redactURL(u *url.URL) string {
+	query := u.Query()
+	if !query.Has("key") {
+		return u.String()
+	}
+	query.Set("key", redacted)
+	masked := *u
+	masked.RawQuery = query.Encode()
+	return masked.String()
+}
+
+const redacted = "REDACTED"
+
+// storedChunk is a write of the response between two flushes
+type storedChunk struct {
+	OffsetMs float64 `json:"offset_ms"`
+	Size     int     `json:"size"`
+}
+
+// LogStore appends finished log entries to a JSONL file. The file is rotated once it is
+// larger than maxSize or older than maxAge, rotated files older than maxAge are deleted.
+type LogStore struct {
+	mutex   sync.Mutex
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	file    *os.File
+	size    int64
+	started time.Time
+}
+
+// OpenLogStore opens the log file at path, limits of 0 disable the rotation
+func OpenLogStore(path string, maxSize int64, maxAge time.Duration) (*LogStore, error) {
+	s := &LogStore{path: path, maxSize: maxSize, maxAge: maxAge}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *LogStore) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size, s.started = file, info.Size(), time.Now()
+	// The age of a file continuing an earlier session is that of its first entry
+	if entries, err := readStoredEntries(s.path, 1); err == nil && len(entries) > 0 {
+		s.started = entries[0].Time
+	}
+	return nil
+}
+
+// rotate renames the current file with the time it was rotated and starts a new one.
+// A name that is taken is not overwritten, the next millisecond is used instead.
+func (s *LogStore) rotate() error {
+	s.file.Close()
+	ext := filepath.Ext(s.path)
+	var rotated string
+	for t := time.Now(); ; t = t.Add(time.Millisecond) {
+		rotated = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(s.path, ext), t.Format("20060102-150405.000"), ext)
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			break
+		}
+	}
+	if err := os.Rename(s.path, rotated); err != nil {
+		return err
+	}
+	s.prune()
+	return s.open()
+}
+
+// rotatedFiles returns the rotated files, newest first
+func (s *LogStore) rotatedFiles() []string {
+	ext := filepath.Ext(s.path)
+	files, _ := filepath.Glob(strings.TrimSuffix(s.path, ext) + "-*" + ext)
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	return files
+}
+
+func (s *LogStore) prune() {
+	if s.maxAge <= 0 {
+		return
+	}
+	for _, file := range s.rotatedFiles() {
+		if info, err := os.Stat(file); err == nil && time.Since(info.ModTime()) > s.maxAge {
+			os.Remove(file)
+		}
+	}
+}
+
+func (s *LogStore) append(entry storedEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.size > 0 && (s.maxSize > 0 && s.size+int64(len(line)) > s.maxSize ||
+		s.maxAge > 0 && time.Since(s.started) > s.maxAge) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// Load returns up to limit of the newest stored entries, newest first. An entry appended
+// again, when it was pinned or tagged, is read from its last line.
+func (s *LogStore) Load(limit int) ([]*RequestLogEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var entries []*RequestLogEntry
+	seen := map[string]bool{}
+	for _, file := range append([]string{s.path}, s.rotatedFiles()...) {
+		stored, err := readStoredEntries(file, 0)
+		if err != nil {
+			return entries, err
+		}
+		for i := len(stored) - 1; i >= 0 && len(entries) < limit; i-- {
+			key := stored[i].Time.Format(time.RFC3339Nano) + "\x00" + stored[i].Summary
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			entries = append(entries, stored[i].entry())
+		}
+		if len(entries) >= limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+func (s *LogStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
+
+// readStoredEntries reads the entries of a log file, at most limit of the first ones unless limit is 0.
+// Lines that are not valid JSON, e.g. cut off by a crash, are skipped.
+func readStoredEntries(path string, limit int) ([]storedEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []storedEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() && (limit == 0 || len(entries) < limit) {
+		var entry storedEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// entry rebuilds a log entry of an earlier session
+func (e storedEntry) entry() *RequestLogEntry {
+	entry := &RequestLogEntry{
+		Timestamp:             e.Time.Format("2006-01-02 15:04:05"),
+		Time:                  e.Time,
+		Summary:               e.Summary,
+		TTFB:                  time.Duration(e.TTFBMs * float64(time.Millisecond)),
+		Duration:              time.Duration(e.DurationMs * float64(time.Millisecond)),
+		History:               true,
+		Pinned:                e.Pinned,
+		Tags:                  e.Tags,
+		saved:                 true,
+		source:                e.Source,
+		body:                  newBuffer(e.Details),
+		requestBody:           []byte(e.RequestBody),
+		requestBodyTruncated:  e.RequestBodyTruncated,
+		responseBody:          []byte(e.ResponseBody),
+		responseBodyTruncated: e.ResponseBodyTruncated,
+	}
+	for _, c := range e.Chunks {
+		entry.chunks = append(entry.chunks, chunk{offset: time.Duration(c.OffsetMs * float64(time.Millisecond)), size: c.Size})
+	}
+	if e.Method != "" {
+		u, _ := url.Parse(e.URL)
+		if u == nil {
+			u = &url.URL{}
+		}
+		entry.Request = &http.Request{Method: e.Method, URL: u, Header: e.RequestHeader}
+		entry.model = requestModel(entry.requestBody, u.Path)
+	}
+	if e.Status != 0 {
+		entry.Response = &http.Response{
+			StatusCode: e.Status,
+			Status:     fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+			Header:     e.ResponseHeader,
+		}
+	}
+	return entry
+}
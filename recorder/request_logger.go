@@ -2,8 +2,13 @@ package recorder
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -14,17 +19,49 @@ import (
 
 type RequestLogEntry struct {
 	Timestamp string
+	Time      time.Time
 	Summary   string
 	Request   *http.Request
 	Response  *http.Response
-	body      *bytes.Buffer
+	TTFB      time.Duration // until the first byte of the response body
+	Duration  time.Duration
+	History   bool     // loaded from the log file of an earlier session
+	Pinned    bool     // kept when older entries are dropped
+	Tags      []string // kept when older entries are dropped as well
+
+	saved                 bool // appended to the store
+	source                string
+	model                 string
+	body                  *bytes.Buffer
+	requestBody           []byte
+	requestBodyTruncated  bool // requestBody holds only the start of the body
+	responseBody          []byte
+	responseBodyTruncated bool // responseBody holds only the start of the body
+	chunks                []chunk
+}
+
+// kept reports whether log is kept when older entries are dropped, or history is reloaded
+func (log *RequestLogEntry) kept() bool {
+	return log.Pinned || len(log.Tags) > 0
+}
+
+// Source returns SourceMocked or SourceProxied
+func (log *RequestLogEntry) Source() string {
+	if log.source == "" {
+		return SourceMocked
+	}
+	return log.source
 }
 
 type RequestLogger struct {
 	mutex       sync.RWMutex
 	requestLogs []*RequestLogEntry
+	filter      LogFilter
+	visible     []*RequestLogEntry // the entries matching filter
 	maxLogs     int
 	logList     *widget.List
+	output      io.Writer
+	store       *LogStore
 }
 
 func NewRequestLogger(maxLogs int) *RequestLogger {
@@ -37,41 +74,276 @@ func (l *RequestLogger) SetLogList(logList *widget.List) {
 	l.logList = logList
 }
 
+// SetOutput prints a line for every new entry to w, used when there is no window
+func (l *RequestLogger) SetOutput(w io.Writer) {
+	l.output = w
+}
+
+// SetStore appends every finished entry to store
+func (l *RequestLogger) SetStore(store *LogStore) {
+	l.store = store
+}
+
+// GetLogCount returns the number of entries matching the filter
 func (l *RequestLogger) GetLogCount() int {
 	l.mutex.RLock()
 	defer l.mutex.RUnlock()
-	return len(l.requestLogs)
+	return len(l.visible)
 }
 
+// GetLog returns the entry at index among those matching the filter. It returns nil when
+// index is out of range, as the entries may have changed since their count was taken.
 func (l *RequestLogger) GetLog(index int) *RequestLogEntry {
 	l.mutex.RLock()
 	defer l.mutex.RUnlock()
-	return l.requestLogs[index]
+	if index < 0 || index >= len(l.visible) {
+		return nil
+	}
+	return l.visible[index]
+}
+
+// SetFilter shows only the entries matching filter
+func (l *RequestLogger) SetFilter(filter LogFilter) {
+	l.mutex.Lock()
+	l.filter = filter
+	l.applyFilter()
+	l.mutex.Unlock()
+	l.refresh()
+}
+
+// SetPinned pins or unpins entry, pinned entries are not dropped for newer ones
+func (l *RequestLogger) SetPinned(entry *RequestLogEntry, pinned bool) {
+	l.update(entry, func() { entry.Pinned = pinned })
+}
+
+// SetTags replaces the tags of entry, tagged entries are not dropped for newer ones
+func (l *RequestLogger) SetTags(entry *RequestLogEntry, tags []string) {
+	l.update(entry, func() { entry.Tags = tags })
+}
+
+// update changes entry with change. An entry already in the store is appended again,
+// Load keeps its newest line.
+func (l *RequestLogger) update(entry *RequestLogEntry, change func()) {
+	l.mutex.Lock()
+	change()
+	l.evict()
+	l.applyFilter()
+	var stored *storedEntry
+	if entry.saved {
+		s := entry.stored()
+		stored = &s
+	}
+	l.mutex.Unlock()
+
+	l.refresh()
+	if l.store != nil && stored != nil {
+		if err := l.store.append(*stored); err != nil {
+			fmt.Fprintf(os.Stderr, "request log: %v\n", err)
+		}
+	}
+}
+
+func (l *RequestLogger) applyFilter() {
+	l.visible = l.visible[:0]
+	for _, entry := range l.requestLogs {
+		if l.filter.match(entry) {
+			l.visible = append(l.visible, entry)
+		}
+	}
+}
+
+// evict drops the oldest entries that are not kept until at most maxLogs are left
+func (l *RequestLogger) evict() {
+	excess := len(l.requestLogs) - l.maxLogs
+	if excess <= 0 {
+		return
+	}
+	// Entries are newest first, the oldest are dropped
+	dropped := map[*RequestLogEntry]bool{}
+	for i := len(l.requestLogs) - 1; i >= 0 && excess > 0; i-- {
+		if !l.requestLogs[i].kept() {
+			dropped[l.requestLogs[i]] = true
+			excess--
+		}
+	}
+	kept := make([]*RequestLogEntry, 0, len(l.requestLogs)-len(dropped))
+	for _, entry := range l.requestLogs {
+		if !dropped[entry] {
+			kept = append(kept, entry)
+		}
+	}
+	l.requestLogs = kept
 }
 
 func (l *RequestLogger) LogWithRequest(log string, req *http.Request, body string) *RequestLogEntry {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
+	now := time.Now()
 	entry := &RequestLogEntry{
-		Timestamp: time.Now().Format("15:04:05"),
+		Timestamp: now.Format("15:04:05"),
+		Time:      now,
 		Summary:   log,
 		Request:   req,
-		body:      bytes.NewBuffer([]byte(body)),
+		body:      newBuffer(body),
+	}
+	if rec := recorderOf(req); rec != nil {
+		entry.Time = rec.start
+		entry.requestBody, entry.requestBodyTruncated = rec.requestBody, rec.requestBodyTruncated
+		entry.source = rec.source
+		rec.entry = entry
+	}
+	if req != nil {
+		entry.model = requestModel(entry.requestBody, req.URL.Path)
 	}
 	l.requestLogs = append([]*RequestLogEntry{entry}, l.requestLogs...)
-	if len(l.requestLogs) > l.maxLogs {
-		l.requestLogs = l.requestLogs[:l.maxLogs]
+	l.evict()
+	l.applyFilter()
+	l.refresh()
+	if l.output != nil {
+		method, path := "---", "---"
+		if req != nil {
+			method = req.Method
+			path = req.URL.Path
+		}
+		fmt.Fprintf(l.output, "%s %s %s %s\n", entry.Timestamp, method, path, log)
+	}
+	return entry
+}
+
+// Finish completes the entry logged last for the request of rec with the recorded response,
+// and appends it to the store
+func (l *RequestLogger) Finish(rec *ResponseRecorder) {
+	l.mutex.Lock()
+	entry := rec.entry
+	if entry == nil {
+		l.mutex.Unlock()
+		return
 	}
+	status := rec.Status()
+	entry.Response = &http.Response{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header:     rec.Header().Clone(),
+	}
+	entry.responseBody, entry.responseBodyTruncated = rec.body.Bytes(), rec.bodyTruncated
+	if rec.bodyTruncated {
+		entry.responseBody = trimPartialRune(entry.responseBody)
+	}
+	entry.chunks = rec.chunks
+	if !rec.firstByte.IsZero() {
+		entry.TTFB = rec.firstByte.Sub(rec.start)
+	}
+	entry.Duration = time.Since(rec.start)
+	entry.saved = l.store != nil
+	stored := entry.stored()
+	l.applyFilter()
+	l.mutex.Unlock()
+
+	l.refresh()
+	if l.store != nil {
+		if err := l.store.append(stored); err != nil {
+			fmt.Fprintf(os.Stderr, "request log: %v\n", err)
+		}
+	}
+}
+
+// LoadHistory adds up to limit entries of earlier sessions from the store after the current ones
+func (l *RequestLogger) LoadHistory(limit int) (int, error) {
+	if l.store == nil {
+		return 0, nil
+	}
+	history, err := l.store.Load(limit)
+
+	l.mutex.Lock()
+	// Earlier entries are replaced by the loaded ones, unless they are pinned or tagged
+	var current, kept []*RequestLogEntry
+	for _, entry := range l.requestLogs {
+		switch {
+		case !entry.History:
+			current = append(current, entry)
+		case entry.kept():
+			kept = append(kept, entry)
+		}
+	}
+	var loaded []*RequestLogEntry
+	for _, entry := range history {
+		// Entries of this session are in the store as well
+		if len(current) > 0 && !entry.Time.Before(current[len(current)-1].Time) {
+			continue
+		}
+		if slices.ContainsFunc(kept, entry.sameAs) {
+			continue
+		}
+		loaded = append(loaded, entry)
+	}
+	history = append(kept, loaded...)
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Time.After(history[j].Time)
+	})
+	l.requestLogs = append(current, history...)
+	l.applyFilter()
+	l.mutex.Unlock()
+
+	l.refresh()
+	return len(loaded), err
+}
+
+// sameAs reports whether log and other were read from the same line of the store
+func (log *RequestLogEntry) sameAs(other *RequestLogEntry) bool {
+	return log.Time.Equal(other.Time) && log.Summary == other.Summary
+}
+
+func (l *RequestLogger) refresh() {
 	if l.logList != nil {
 		fyne.Do(l.logList.Refresh)
 	}
-	return entry
+}
+
+// stored returns the JSON line of the entry, with secrets of the request masked
+func (log *RequestLogEntry) stored() storedEntry {
+	stored := storedEntry{
+		Time:                  log.Time,
+		Summary:               log.Summary,
+		Source:                log.source,
+		RequestBody:           string(log.requestBody),
+		RequestBodyTruncated:  log.requestBodyTruncated,
+		ResponseBody:          string(log.responseBody),
+		ResponseBodyTruncated: log.responseBodyTruncated,
+		Pinned:                log.Pinned,
+		Tags:                  log.Tags,
+		TTFBMs:                float64(log.TTFB.Microseconds()) / 1000,
+		DurationMs:            float64(log.Duration.Microseconds()) / 1000,
+	}
+	for _, c := range log.chunks {
+		stored.Chunks = append(stored.Chunks, storedChunk{OffsetMs: float64(c.offset.Microseconds()) / 1000, Size: c.size})
+	}
+	if log.body != nil {
+		stored.Details = log.body.String()
+	}
+	if log.Request != nil {
+		stored.Method = log.Request.Method
+		stored.URL = redactURL(log.Request.URL)
+		stored.RequestHeader = redactHeader(log.Request.Header)
+	}
+	if log.Response != nil {
+		stored.Status = log.Response.StatusCode
+		stored.ResponseHeader = redactHeader(log.Response.Header)
+	}
+	return stored
+}
+
+func newBuffer(s string) *bytes.Buffer {
+	return bytes.NewBufferString(s)
 }
 
 func (l *RequestLogger) FormatLogDetails(log *RequestLogEntry) string {
 	var details strings.Builder
-	details.WriteString(fmt.Sprintf("Time: %s\n\n", log.Timestamp))
+	details.WriteString(fmt.Sprintf("Time: %s\n", log.Timestamp))
+	if log.Duration > 0 {
+		details.WriteString(fmt.Sprintf("TTFB: %s, Duration: %s\n", log.TTFB.Round(time.Millisecond), log.Duration.Round(time.Millisecond)))
+	}
+	details.WriteString("\n")
 
 	// Request details
 	details.WriteString("=== Request ===\n")
@@ -85,6 +357,13 @@ func (l *RequestLogger) FormatLogDetails(log *RequestLogEntry) string {
 	} else {
 		details.WriteString("No request information available\n")
 	}
+	if len(log.requestBody) > 0 {
+		details.WriteString("\n=== Request Body ===\n")
+		writeBody(&details, log.requestBody)
+		if log.requestBodyTruncated {
+			details.WriteString(fmt.Sprintf("\n... truncated after %d bytes\n", len(log.requestBody)))
+		}
+	}
 
 	// Response details
 	details.WriteString("\n=== Response ===\n")
@@ -97,12 +376,31 @@ func (l *RequestLogger) FormatLogDetails(log *RequestLogEntry) string {
 	} else {
 		details.WriteString("No response information available\n")
 	}
+	log.writeTimeline(&details)
 
 	// Body
-	if log.body != nil {
+	if log.body != nil && log.body.Len() > 0 {
 		details.WriteString("\n=== Body ===\n")
 		details.WriteString(log.body.String())
 	}
+	if len(log.responseBody) > 0 {
+		details.WriteString("\n=== Response Body ===\n")
+		writeBody(&details, log.responseBody)
+		if log.responseBodyTruncated {
+			details.WriteString(fmt.Sprintf("\n... truncated after %d bytes\n", len(log.responseBody)))
+		}
+	}
 
 	return details.String()
 }
+
+// writeBody writes a body, indenting JSON
+func writeBody(details *strings.Builder, body []byte) {
+	var indented bytes.Buffer
+	if json.Indent(&indented, body, "", "  ") == nil {
+		details.Write(indented.Bytes())
+		details.WriteString("\n")
+		return
+	}
+	details.Write(body)
+}
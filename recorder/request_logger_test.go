@@ -0,0 +1,78 @@
+package recorder
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestEvictKeepsPinnedAndTagged(t *testing.T) {
+	l := NewRequestLogger(2)
+	pinned := l.LogWithRequest("pinned", nil, "")
+	tagged := l.LogWithRequest("tagged", nil, "")
+	l.SetPinned(pinned, true)
+	l.SetTags(tagged, []string{"bug"})
+	for _, summary := range []string{"a", "b", "c"} {
+		l.LogWithRequest(summary, nil, "")
+	}
+
+	var summaries []string
+	for i := 0; i < l.GetLogCount(); i++ {
+		summaries = append(summaries, l.GetLog(i).Summary)
+	}
+	if !slices.Contains(summaries, "pinned") || !slices.Contains(summaries, "tagged") {
+		t.Errorf("entries left %q, want the pinned and the tagged one", summaries)
+	}
+}
+
+func TestGetLogOutOfRange(t *testing.T) {
+	l := NewRequestLogger(10)
+	l.LogWithRequest("only", nil, "")
+	for _, index := range []int{-1, 1, 5} {
+		if log := l.GetLog(index); log != nil {
+			t.Errorf("GetLog(%d) = %q, want nil", index, log.Summary)
+		}
+	}
+}
+
+func TestStoreKeepsPinsAndTags(t *testing.T) {
+	store, err := OpenLogStore(filepath.Join(t.TempDir(), "requests.jsonl"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	l := NewRequestLogger(10)
+	l.SetStore(store)
+
+	var entries []*RequestLogEntry
+	for _, summary := range []string{"first", "second"} {
+		rec := NewResponseRecorder(httptest.NewRecorder())
+		req := WithRecorder(httptest.NewRequest("POST", "/v1/chat/completions", nil), rec)
+		entries = append(entries, l.LogWithRequest(summary, req, ""))
+		rec.Write([]byte("ok"))
+		l.Finish(rec)
+	}
+	l.SetPinned(entries[0], true)
+	l.SetTags(entries[0], []string{"slow", "bug"})
+	l.SetPinned(entries[1], true)
+	l.SetPinned(entries[1], false)
+
+	loaded, err := store.Load(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]*RequestLogEntry{}
+	for _, entry := range loaded {
+		if got[entry.Summary] != nil {
+			t.Errorf("%q loaded twice", entry.Summary)
+		}
+		got[entry.Summary] = entry
+	}
+	if e := got["first"]; e == nil || !e.Pinned || !slices.Equal(e.Tags, []string{"slow", "bug"}) {
+		t.Errorf("first entry loaded as %+v, want pinned and tagged", e)
+	}
+	if e := got["second"]; e == nil || e.Pinned || len(e.Tags) > 0 {
+		t.Errorf("second entry loaded as %+v, want neither pinned nor tagged", e)
+	}
+}
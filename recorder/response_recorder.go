@@ -3,26 +3,95 @@ package recorder
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
+	"io"
 	"net"
 	"net/http"
+	"time"
+	"unicode/utf8"
 )
 
 // ResponseRecorder is a custom implementation of http.ResponseWriter that records the response
 type ResponseRecorder struct {
 	http.ResponseWriter
 	statusCode int
-	logger     *RequestLogEntry
+	body       bytes.Buffer
+	start      time.Time
+	firstByte  time.Time
+	chunks     []chunk
+	chunkOpen  bool             // writes go to the last chunk until the next flush
+	entry      *RequestLogEntry // the last entry logged for the request
+
+	bodyLimit            int  // bytes of the bodies kept in the log
+	bodyTruncated        bool // body holds only the start of the response
+	requestBody          []byte
+	requestBodyTruncated bool
+	source               string
 }
 
-func NewResponseRecorder(w http.ResponseWriter, logger *RequestLogEntry) *ResponseRecorder {
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
 	return &ResponseRecorder{
 		ResponseWriter: w,
-		logger:         logger,
+		start:          time.Now(),
+	}
+}
+
+// CaptureRequestBody keeps up to limit bytes of the body of req for the log, and of the
+// response body. The captured bytes are put back in front of the rest, so the body can still
+// be read whole.
+func (r *ResponseRecorder) CaptureRequestBody(req *http.Request, limit int) {
+	r.bodyLimit = limit
+	if limit <= 0 || req.Body == nil || req.Body == http.NoBody {
+		return
 	}
+	captured, err := io.ReadAll(io.LimitReader(req.Body, int64(limit)+1))
+	if len(captured) > limit {
+		r.requestBody, r.requestBodyTruncated = trimPartialRune(captured[:limit]), true
+	} else {
+		r.requestBody = captured
+	}
+	rest := req.Body
+	if err != nil {
+		rest = io.NopCloser(&errReader{err})
+	}
+	req.Body = readCloser{io.MultiReader(bytes.NewReader(captured), rest), req.Body}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// errReader returns the error that interrupted the capture to the reader of the body
+type errReader struct{ err error }
+
+func (e *errReader) Read([]byte) (int, error) { return 0, e.err }
+
+type recorderKey struct{}
+
+// WithRecorder returns r with rec attached, entries logged for the request get the response recorded by rec
+func WithRecorder(r *http.Request, rec *ResponseRecorder) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), recorderKey{}, rec))
+}
+
+// MarkProxied marks the entries logged for r as proxied
+func MarkProxied(r *http.Request) {
+	if rec := recorderOf(r); rec != nil {
+		rec.source = SourceProxied
+	}
+}
+
+func recorderOf(r *http.Request) *ResponseRecorder {
+	if r == nil {
+		return nil
+	}
+	rec, _ := r.Context().Value(recorderKey{}).(*ResponseRecorder)
+	return rec
 }
 
 func (r *ResponseRecorder) Flush() {
+	r.chunkOpen = false
 	if f, ok := r.ResponseWriter.(http.Flusher); ok {
 		f.Flush()
 	}
@@ -42,16 +111,51 @@ func (r *ResponseRecorder) Push(target string, opts *http.PushOptions) error {
 	return errors.New("ResponseWriter does not implement Pusher")
 }
 
+func (r *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 func (r *ResponseRecorder) WriteHeader(statusCode int) {
-	r.statusCode = statusCode
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
 	r.ResponseWriter.WriteHeader(statusCode)
 }
 
 func (r *ResponseRecorder) Write(b []byte) (int, error) {
-	r.logger.body.Write(b)
+	now := time.Now()
+	if r.firstByte.IsZero() {
+		r.firstByte = now
+	}
+	if len(b) > 0 {
+		if !r.chunkOpen {
+			r.chunks = append(r.chunks, chunk{offset: now.Sub(r.start)})
+			r.chunkOpen = true
+		}
+		r.chunks[len(r.chunks)-1].size += len(b)
+	}
+	if keep := r.bodyLimit - r.body.Len(); keep < len(b) {
+		r.body.Write(b[:max(keep, 0)])
+		r.bodyTruncated = true
+	} else {
+		r.body.Write(b)
+	}
 	return r.ResponseWriter.Write(b)
 }
 
+// trimPartialRune drops the bytes of a character cut off at the end of b
+func trimPartialRune(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
+
 func (r *ResponseRecorder) Status() int {
 	if r.statusCode == 0 {
 		return http.StatusOK
@@ -60,5 +164,5 @@ func (r *ResponseRecorder) Status() int {
 }
 
 func (r *ResponseRecorder) Body() *bytes.Buffer {
-	return r.logger.body
+	return &r.body
 }
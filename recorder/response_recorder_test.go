@@ -0,0 +1,73 @@
+package recorder
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponseRecorderBodyLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		writes    []string
+		body      string
+		truncated bool
+	}{
+		{"under the limit", 10, []string{"abc", "def"}, "abcdef", false},
+		{"at the limit", 6, []string{"abc", "def"}, "abcdef", false},
+		{"over the limit", 4, []string{"abc", "def"}, "abcd", true},
+		{"no body kept", 0, []string{"abc"}, "", true},
+		{"cut inside a character", 5, []string{"日本"}, "日", true},
+		{"cut after a character", 6, []string{"日本語"}, "日本", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rec := NewResponseRecorder(w)
+			rec.CaptureRequestBody(httptest.NewRequest("GET", "/", nil), tt.limit)
+			for _, s := range tt.writes {
+				rec.Write([]byte(s))
+			}
+			if got := w.Body.String(); got != strings.Join(tt.writes, "") {
+				t.Errorf("client got %q, want the whole body", got)
+			}
+			body := rec.body.Bytes()
+			if rec.bodyTruncated {
+				body = trimPartialRune(body)
+			}
+			if string(body) != tt.body || rec.bodyTruncated != tt.truncated {
+				t.Errorf("recorded %q, truncated %v, want %q, %v", body, rec.bodyTruncated, tt.body, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestCaptureRequestBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		body      string
+		captured  string
+		truncated bool
+	}{
+		{"whole body", 10, "hello", "hello", false},
+		{"cut body", 3, "hello", "hel", true},
+		{"cut inside a character", 2, "héllo", "h", true},
+		{"cut inside the first character", 2, "日本", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			rec := NewResponseRecorder(httptest.NewRecorder())
+			rec.CaptureRequestBody(req, tt.limit)
+			if string(rec.requestBody) != tt.captured || rec.requestBodyTruncated != tt.truncated {
+				t.Errorf("captured %q, truncated %v, want %q, %v", rec.requestBody, rec.requestBodyTruncated, tt.captured, tt.truncated)
+			}
+			if rest, _ := io.ReadAll(req.Body); string(rest) != tt.body {
+				t.Errorf("body read after the capture = %q, want %q", rest, tt.body)
+			}
+		})
+	}
+}
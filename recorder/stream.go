@@ -0,0 +1,326 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxTimelineChunks limits the chunks listed in the timeline of the details
+const maxTimelineChunks = 200
+
+// chunk is what was written to the response between two flushes
+type chunk struct {
+	offset time.Duration // from the start of the request to the first write
+	size   int
+}
+
+// streamMessage is the final message assembled from the deltas of a streamed response
+type streamMessage struct {
+	content   strings.Builder
+	reasoning strings.Builder
+	toolCalls []*streamToolCall
+	byKey     map[string]*streamToolCall
+}
+
+type streamToolCall struct {
+	name      string
+	arguments strings.Builder
+}
+
+// toolCall returns the call of key, a new one is added when there is none. An empty key always adds a call.
+func (m *streamMessage) toolCall(key, name string) *streamToolCall {
+	if call, ok := m.byKey[key]; ok && key != "" {
+		if name != "" {
+			call.name = name
+		}
+		return call
+	}
+	call := &streamToolCall{name: name}
+	m.toolCalls = append(m.toolCalls, call)
+	if m.byKey == nil {
+		m.byKey = map[string]*streamToolCall{}
+	}
+	m.byKey[key] = call
+	return call
+}
+
+func (m *streamMessage) empty() bool {
+	return m.content.Len() == 0 && m.reasoning.Len() == 0 && len(m.toolCalls) == 0
+}
+
+// streamEvent holds the delta fields of the stream formats of OpenAI, Anthropic, Gemini and Ollama
+type streamEvent struct {
+	// OpenAI chat completions and completions
+	Choices []struct {
+		Text  string `json:"text"`
+		Delta struct {
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content"`
+			Reasoning        string `json:"reasoning"`
+			ToolCalls        []struct {
+				Index    int `json:"index"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+	} `json:"choices"`
+
+	// Anthropic messages and OpenAI responses
+	Type         string          `json:"type"`
+	Index        int             `json:"index"`
+	OutputIndex  int             `json:"output_index"`
+	Delta        json.RawMessage `json:"delta"`
+	ContentBlock struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"content_block"`
+	Item struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"item"`
+
+	// Gemini
+	Candidates []struct {
+		Content struct {
+			Parts []struct {
+				Text         string `json:"text"`
+				Thought      bool   `json:"thought"`
+				FunctionCall *struct {
+					Name string          `json:"name"`
+					Args json.RawMessage `json:"args"`
+				} `json:"functionCall"`
+			} `json:"parts"`
+		} `json:"content"`
+	} `json:"candidates"`
+
+	// Ollama chat and generate
+	Message struct {
+		Content   string `json:"content"`
+		Thinking  string `json:"thinking"`
+		ToolCalls []struct {
+			Function struct {
+				Name      string          `json:"name"`
+				Arguments json.RawMessage `json:"arguments"`
+			} `json:"function"`
+		} `json:"tool_calls"`
+	} `json:"message"`
+	Response string `json:"response"`
+	Thinking string `json:"thinking"`
+	Done     *bool  `json:"done"`
+}
+
+// add adds the deltas of a line of the stream. It reports whether the line carried any,
+// and whether it was an event at all.
+func (m *streamMessage) add(line []byte) (carried, ok bool) {
+	line = bytes.TrimSpace(line)
+	if bytes.HasPrefix(line, []byte("data:")) {
+		line = bytes.TrimSpace(line[len("data:"):])
+	}
+	// Elements of a Gemini JSON array stream
+	line = bytes.TrimRight(bytes.TrimLeft(line, "[,"), ",]")
+	if len(line) == 0 || line[0] != '{' {
+		return false, false
+	}
+
+	var ev streamEvent
+	// Fields of other types in other formats are skipped, the rest is still decoded
+	if err := json.Unmarshal(line, &ev); err != nil {
+		if _, ok := err.(*json.UnmarshalTypeError); !ok {
+			return false, false
+		}
+	}
+
+	before := m.size()
+	for _, choice := range ev.Choices {
+		m.content.WriteString(choice.Text)
+		m.content.WriteString(choice.Delta.Content)
+		m.reasoning.WriteString(choice.Delta.ReasoningContent)
+		m.reasoning.WriteString(choice.Delta.Reasoning)
+		for _, call := range choice.Delta.ToolCalls {
+			m.toolCall(strconv.Itoa(call.Index), call.Function.Name).arguments.WriteString(call.Function.Arguments)
+		}
+	}
+
+	var delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		PartialJSON string `json:"partial_json"`
+	}
+	var text string
+	switch ev.Type {
+	case "content_block_start":
+		if ev.ContentBlock.Type == "tool_use" {
+			m.toolCall(strconv.Itoa(ev.Index), ev.ContentBlock.Name)
+		}
+	case "content_block_delta":
+		json.Unmarshal(ev.Delta, &delta)
+		m.content.WriteString(delta.Text)
+		m.reasoning.WriteString(delta.Thinking)
+		if delta.Type == "input_json_delta" {
+			m.toolCall(strconv.Itoa(ev.Index), "").arguments.WriteString(delta.PartialJSON)
+		}
+	case "response.output_item.added":
+		if ev.Item.Type == "function_call" {
+			m.toolCall(strconv.Itoa(ev.OutputIndex), ev.Item.Name)
+		}
+	case "response.output_text.delta":
+		json.Unmarshal(ev.Delta, &text)
+		m.content.WriteString(text)
+	case "response.reasoning_summary_text.delta", "response.reasoning_text.delta":
+		json.Unmarshal(ev.Delta, &text)
+		m.reasoning.WriteString(text)
+	case "response.function_call_arguments.delta":
+		json.Unmarshal(ev.Delta, &text)
+		m.toolCall(strconv.Itoa(ev.OutputIndex), "").arguments.WriteString(text)
+	}
+
+	for _, candidate := range ev.Candidates {
+		for _, part := range candidate.Content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				m.toolCall("", part.FunctionCall.Name).arguments.Write(part.FunctionCall.Args)
+			case part.Thought:
+				m.reasoning.WriteString(part.Text)
+			default:
+				m.content.WriteString(part.Text)
+			}
+		}
+	}
+
+	if ev.Done != nil {
+		m.content.WriteString(ev.Message.Content)
+		m.content.WriteString(ev.Response)
+		m.reasoning.WriteString(ev.Message.Thinking)
+		m.reasoning.WriteString(ev.Thinking)
+		for _, call := range ev.Message.ToolCalls {
+			m.toolCall("", call.Function.Name).arguments.Write(call.Function.Arguments)
+		}
+	}
+	return m.size() > before, true
+}
+
+// size counts what was assembled so far, a new tool call counts as well
+func (m *streamMessage) size() int {
+	size := m.content.Len() + m.reasoning.Len() + len(m.toolCalls)
+	for _, call := range m.toolCalls {
+		size += call.arguments.Len()
+	}
+	return size
+}
+
+// assemble reads the lines of body and returns the final message, and the time of the
+// chunk holding the first delta as time to first token, -1 without deltas
+func assemble(body []byte, chunks []chunk) (*streamMessage, time.Duration) {
+	m := &streamMessage{}
+	ttft := time.Duration(-1)
+	var pending []byte
+	pos := 0
+	for i, c := range chunks {
+		end := pos + c.size
+		if end > len(body) || i == len(chunks)-1 {
+			end = len(body)
+		}
+		pending = append(pending, body[pos:end]...)
+		pos = end
+		for {
+			n := bytes.IndexByte(pending, '\n')
+			if n < 0 {
+				break
+			}
+			if delta, _ := m.add(pending[:n]); delta && ttft < 0 {
+				ttft = c.offset
+			}
+			pending = pending[n+1:]
+		}
+		// A chunk may end with an event but no newline, like the elements of a Gemini JSON array
+		if delta, ok := m.add(pending); ok {
+			if delta && ttft < 0 {
+				ttft = c.offset
+			}
+			pending = nil
+		}
+	}
+	return m, ttft
+}
+
+// isStream reports whether the response was written in more than one chunk
+func (log *RequestLogEntry) isStream() bool {
+	return len(log.chunks) > 1
+}
+
+// writeTimeline writes the chunks of a streamed response with the gaps between them,
+// and the message assembled from their deltas
+func (log *RequestLogEntry) writeTimeline(details *strings.Builder) {
+	if !log.isStream() {
+		return
+	}
+	message, ttft := assemble(log.responseBody, log.chunks)
+
+	var minGap, maxGap, totalGap time.Duration
+	for i := 1; i < len(log.chunks); i++ {
+		gap := log.chunks[i].offset - log.chunks[i-1].offset
+		if i == 1 || gap < minGap {
+			minGap = gap
+		}
+		if gap > maxGap {
+			maxGap = gap
+		}
+		totalGap += gap
+	}
+	avgGap := totalGap / time.Duration(len(log.chunks)-1)
+
+	details.WriteString("\n=== Stream ===\n")
+	details.WriteString(fmt.Sprintf("Chunks: %d, Duration: %s\n", len(log.chunks), log.Duration.Round(time.Millisecond)))
+	if ttft >= 0 {
+		details.WriteString(fmt.Sprintf("TTFB: %s, TTFT: %s\n", roundGap(log.TTFB), roundGap(ttft)))
+	}
+	details.WriteString(fmt.Sprintf("Gaps: min %s, avg %s, max %s\n", roundGap(minGap), roundGap(avgGap), roundGap(maxGap)))
+	details.WriteString("Timeline:\n")
+	for i, c := range log.chunks {
+		if i == maxTimelineChunks {
+			details.WriteString(fmt.Sprintf("  ... %d more chunks\n", len(log.chunks)-i))
+			break
+		}
+		var gap time.Duration
+		if i > 0 {
+			gap = c.offset - log.chunks[i-1].offset
+		}
+		details.WriteString(fmt.Sprintf("  %4d  %10s  +%-10s %d bytes\n", i+1, c.offset.Round(time.Millisecond), roundGap(gap), c.size))
+	}
+
+	if message.empty() {
+		return
+	}
+	details.WriteString("\n=== Final Message ===\n")
+	if message.reasoning.Len() > 0 {
+		details.WriteString("Reasoning:\n")
+		details.WriteString(message.reasoning.String())
+		details.WriteString("\n")
+	}
+	if message.content.Len() > 0 {
+		details.WriteString("Content:\n")
+		details.WriteString(message.content.String())
+		details.WriteString("\n")
+	}
+	if len(message.toolCalls) > 0 {
+		details.WriteString("Tool calls:\n")
+		for _, call := range message.toolCalls {
+			details.WriteString(fmt.Sprintf("  %s(%s)\n", call.name, call.arguments.String()))
+		}
+	}
+}
+
+// roundGap rounds to milliseconds, shorter durations to microseconds
+func roundGap(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(time.Millisecond)
+}
@@ -0,0 +1,178 @@
+package recorder
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAssemble(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunks    []string // written 10ms apart
+		content   string
+		reasoning string
+		toolCalls []string
+		ttft      time.Duration
+	}{
+		{
+			name: "openai chat completions",
+			chunks: []string{
+				"data: {\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\"}}]}\n\n",
+				"data: {\"choices\":[{\"index\":0,\"delta\":{\"reasoning_content\":\"Weather ",
+				"asked\"}}]}\n\n",
+				"data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Let me \"}}]}\n\ndata: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"check.\"}}]}\n\n",
+				"data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"type\":\"function\",\"function\":{\"name\":\"get_weather\",\"arguments\":\"\"}}]}}]}\n\n",
+				"data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"{\\\"city\\\":\"}}]}}]}\n\n",
+				"data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"\\\"Paris\\\"}\"}}]}}]}\n\n",
+				"data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"tool_calls\"}]}\n\ndata: [DONE]\n\n",
+			},
+			content:   "Let me check.",
+			reasoning: "Weather asked",
+			toolCalls: []string{`get_weather({"city":"Paris"})`},
+			ttft:      20 * time.Millisecond,
+		},
+		{
+			name: "openai completions",
+			chunks: []string{
+				"data: {\"choices\":[{\"text\":\"Once\",\"index\":0}]}\n\n",
+				"data: {\"choices\":[{\"text\":\" upon\",\"index\":0}]}\n\n",
+				"data: [DONE]\n\n",
+			},
+			content: "Once upon",
+			ttft:    0,
+		},
+		{
+			name: "openai responses",
+			chunks: []string{
+				"event: response.created\ndata: {\"type\":\"response.created\",\"response\":{\"id\":\"resp_1\",\"status\":\"in_progress\"}}\n\n",
+				"event: response.reasoning_summary_text.delta\ndata: {\"type\":\"response.reasoning_summary_text.delta\",\"output_index\":0,\"delta\":\"Thinking\"}\n\n",
+				"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"output_index\":1,\"delta\":\"Hi\"}\n\n",
+				"event: response.output_item.added\ndata: {\"type\":\"response.output_item.added\",\"output_index\":2,\"item\":{\"type\":\"function_call\",\"name\":\"search\",\"arguments\":\"\"}}\n\n",
+				"event: response.function_call_arguments.delta\ndata: {\"type\":\"response.function_call_arguments.delta\",\"output_index\":2,\"delta\":\"{\\\"q\\\":\"}\n\n",
+				"event: response.function_call_arguments.delta\ndata: {\"type\":\"response.function_call_arguments.delta\",\"output_index\":2,\"delta\":\"\\\"go\\\"}\"}\n\n",
+				"event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"id\":\"resp_1\",\"status\":\"completed\"}}\n\n",
+			},
+			content:   "Hi",
+			reasoning: "Thinking",
+			toolCalls: []string{`search({"q":"go"})`},
+			ttft:      10 * time.Millisecond,
+		},
+		{
+			name: "anthropic messages",
+			chunks: []string{
+				"event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"role\":\"assistant\",\"content\":[]}}\n\n",
+				"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"thinking\",\"thinking\":\"\"}}\n\n",
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"thinking_delta\",\"thinking\":\"Hmm\"}}\n\n",
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"text_delta\",\"text\":\"Sure\"}}\n\n",
+				"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":2,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"get_time\",\"input\":{}}}\n\n",
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":2,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"tz\\\":\"}}\n\n",
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":2,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"\\\"UTC\\\"}\"}}\n\n",
+				"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"}}\n\nevent: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+			},
+			content:   "Sure",
+			reasoning: "Hmm",
+			toolCalls: []string{`get_time({"tz":"UTC"})`},
+			ttft:      20 * time.Millisecond,
+		},
+		{
+			name: "gemini sse",
+			chunks: []string{
+				"data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"Plan\",\"thought\":true}]}}]}\r\n\r\n",
+				"data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"Hello\"}]}}]}\r\n\r\n",
+				"data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"functionCall\":{\"name\":\"lookup\",\"args\":{\"id\":7}}}]},\"finishReason\":\"STOP\"}]}\r\n\r\n",
+			},
+			content:   "Hello",
+			reasoning: "Plan",
+			toolCalls: []string{`lookup({"id":7})`},
+			ttft:      0,
+		},
+		{
+			name: "gemini json array",
+			chunks: []string{
+				"[{\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"Hel\"}]}}]}",
+				",\r\n{\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"lo\"}]},\"finishReason\":\"STOP\"}]}",
+				"]",
+			},
+			content: "Hello",
+			ttft:    0,
+		},
+		{
+			name: "ollama chat",
+			chunks: []string{
+				"{\"model\":\"llama3\",\"message\":{\"role\":\"assistant\",\"content\":\"\",\"thinking\":\"Think\"},\"done\":false}\n",
+				"{\"model\":\"llama3\",\"message\":{\"role\":\"assistant\",\"content\":\"Hi\"},\"done\":false}\n",
+				"{\"model\":\"llama3\",\"message\":{\"role\":\"assistant\",\"content\":\"\",\"tool_calls\":[{\"function\":{\"name\":\"add\",\"arguments\":{\"a\":1,\"b\":2}}}]},\"done\":false}\n",
+				"{\"model\":\"llama3\",\"message\":{\"role\":\"assistant\",\"content\":\"\"},\"done\":true,\"done_reason\":\"stop\"}\n",
+			},
+			content:   "Hi",
+			reasoning: "Think",
+			toolCalls: []string{`add({"a":1,"b":2})`},
+			ttft:      0,
+		},
+		{
+			name: "ollama generate",
+			chunks: []string{
+				"{\"model\":\"llama3\",\"response\":\"\",\"done\":false}\n",
+				"{\"model\":\"llama3\",\"response\":\"Why\",\"thinking\":\"Joke\",\"done\":false}\n",
+				"{\"model\":\"llama3\",\"response\":\" not\",\"done\":false}\n",
+				"{\"model\":\"llama3\",\"response\":\"\",\"done\":true,\"context\":[1,2,3]}\n",
+			},
+			content:   "Why not",
+			reasoning: "Joke",
+			ttft:      10 * time.Millisecond,
+		},
+		{
+			name: "no deltas",
+			chunks: []string{
+				"data: {\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\"}}]}\n\n",
+				"data: [DONE]\n\n",
+			},
+			ttft: -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body []byte
+			var chunks []chunk
+			for i, c := range tt.chunks {
+				body = append(body, c...)
+				chunks = append(chunks, chunk{offset: time.Duration(i) * 10 * time.Millisecond, size: len(c)})
+			}
+
+			m, ttft := assemble(body, chunks)
+			if got := m.content.String(); got != tt.content {
+				t.Errorf("content = %q, want %q", got, tt.content)
+			}
+			if got := m.reasoning.String(); got != tt.reasoning {
+				t.Errorf("reasoning = %q, want %q", got, tt.reasoning)
+			}
+			var calls []string
+			for _, call := range m.toolCalls {
+				calls = append(calls, fmt.Sprintf("%s(%s)", call.name, call.arguments.String()))
+			}
+			if !reflect.DeepEqual(calls, tt.toolCalls) {
+				t.Errorf("tool calls = %q, want %q", calls, tt.toolCalls)
+			}
+			if ttft != tt.ttft {
+				t.Errorf("ttft = %s, want %s", ttft, tt.ttft)
+			}
+		})
+	}
+}
+
+// The chunks of a response may be split anywhere, the assembled message must not change
+func TestAssembleSplitChunks(t *testing.T) {
+	body := "data: {\"choices\":[{\"delta\":{\"content\":\"héllo \"}}]}\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\"wörld\"}}]}\n\ndata: [DONE]\n\n"
+	for size := 1; size <= len(body); size++ {
+		var chunks []chunk
+		for pos := 0; pos < len(body); pos += size {
+			chunks = append(chunks, chunk{offset: time.Duration(pos), size: min(size, len(body)-pos)})
+		}
+		if m, _ := assemble([]byte(body), chunks); m.content.String() != "héllo wörld" {
+			t.Errorf("chunks of %d bytes: content = %q", size, m.content.String())
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"mock-stream/util"
+)
+
+type responsesRequest struct {
+	Model        string          `json:"model"`
+	Stream       bool            `json:"stream"`
+	Instructions string          `json:"instructions"`
+	Input        json.RawMessage `json:"input"`
+}
+
+// messages returns the input as messages, a plain string input is one user message
+func (r *responsesRequest) messages() []chatMessage {
+	var text string
+	if err := json.Unmarshal(r.Input, &text); err == nil {
+		return []chatMessage{{Role: "user", Content: r.Input}}
+	}
+	var messages []chatMessage
+	json.Unmarshal(r.Input, &messages)
+	return messages
+}
+
+func (r *responsesRequest) responseModel() string {
+	if r.Model == "" {
+		return "mock-stream"
+	}
+	return r.Model
+}
+
+// handleResponses mocks the OpenAI Responses API (/v1/responses)
+func handleResponses(w http.ResponseWriter, r *http.Request) {
+	var req responsesRequest
+	body, parseErr := readJSONBody(r, &req)
+	if model := routeParam(r, "model"); model != "" {
+		req.Model = model
+	}
+
+	resp, ok := resolveMock(w, r, &mockRequest{
+		API:      apiResponses,
+		Model:    req.Model,
+		Stream:   req.Stream,
+		Messages: req.messages(),
+		Body:     body,
+	}, parseErr)
+	if !ok {
+		return
+	}
+
+	s := newResponsesStream(w, &req, resp)
+	if !req.Stream {
+		s.writeResponse()
+		return
+	}
+	s.streamResponse()
+}
+
+// responsesStream builds a response object and streams it as typed events with sequence numbers
+type responsesStream struct {
+	w        http.ResponseWriter
+	req      *responsesRequest
+	resp     mockResponse
+	response map[string]interface{}
+	output   []interface{}
+	sequence int
+}
+
+func newResponsesStream(w http.ResponseWriter, req *responsesRequest, resp mockResponse) *responsesStream {
+	s := &responsesStream{w: w, req: req, resp: resp, output: []interface{}{}}
+	s.response = map[string]interface{}{
+		"id":                  util.RandomID("resp_", 32),
+		"object":              "response",
+		"created_at":          time.Now().Unix(),
+		"status":              "in_progress",
+		"model":               req.responseModel(),
+		"instructions":        nil,
+		"output":              s.output,
+		"parallel_tool_calls": true,
+		"usage":               nil,
+	}
+	if req.Instructions != "" {
+		s.response["instructions"] = req.Instructions
+	}
+	return s
+}
+
+// complete fills in the final output and usage
+func (s *responsesStream) complete() {
+	inputTokens := promptTokens(s.req.messages()) + util.EstimateTokens(s.req.Instructions)
+	outputTokens := s.resp.completionTokens()
+	s.response["status"] = "completed"
+	s.response["output"] = s.output
+	s.response["usage"] = map[string]interface{}{
+		"input_tokens":          inputTokens,
+		"input_tokens_details":  map[string]int{"cached_tokens": 0},
+		"output_tokens":         outputTokens,
+		"output_tokens_details": map[string]int{"reasoning_tokens": util.EstimateTokens(s.resp.Thinking)},
+		"total_tokens":          inputTokens + outputTokens,
+	}
+}
+
+func reasoningItem(id, summary string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":      id,
+		"type":    "reasoning",
+		"summary": []interface{}{map[string]string{"type": "summary_text", "text": summary}},
+	}
+}
+
+func outputText(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "output_text",
+		"text":        text,
+		"annotations": []interface{}{},
+	}
+}
+
+func messageItem(id, status string, content []interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"id":      id,
+		"type":    "message",
+		"status":  status,
+		"role":    "assistant",
+		"content": content,
+	}
+}
+
+func functionCallItem(id, callID, status string, call ToolCall, arguments string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        id,
+		"type":      "function_call",
+		"status":    status,
+		"call_id":   callID,
+		"name":      call.Name,
+		"arguments": arguments,
+	}
+}
+
+func (s *responsesStream) writeResponse() {
+	if s.resp.RawMode {
+		s.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(s.w, s.resp.Thinking+s.resp.Content)
+		return
+	}
+
+	if s.resp.Thinking != "" {
+		s.output = append(s.output, reasoningItem(util.RandomID("rs_", 32), s.resp.Thinking))
+	}
+	if s.resp.Content != "" {
+		s.output = append(s.output, messageItem(util.RandomID("msg_", 32), "completed", []interface{}{outputText(s.resp.Content)}))
+	}
+	for _, call := range s.resp.ToolCalls {
+		s.output = append(s.output, functionCallItem(util.RandomID("fc_", 32), util.RandomID("call_", 24), "completed", call, call.Arguments))
+	}
+	s.complete()
+
+	s.w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(s.w).Encode(s.response)
+}
+
+func (s *responsesStream) event(eventType string, data map[string]interface{}) {
+	if s.resp.RawMode {
+		return
+	}
+	data["type"] = eventType
+	data["sequence_number"] = s.sequence
+	s.sequence++
+	jsonData, _ := json.Marshal(data)
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", eventType, jsonData)
+}
+
+// deltas streams chunks as events of eventType, built by delta
+func (s *responsesStream) deltas(eventType string, chunks []string, p pace, delta func(ch string) map[string]interface{}) {
+	streamChunks(s.w, chunks, p, func(ch string) {
+		if s.resp.RawMode {
+			fmt.Fprintf(s.w, "%s\n", ch)
+			return
+		}
+		s.event(eventType, delta(ch))
+	})
+}
+
+// item streams the events of one output item between output_item.added and output_item.done
+func (s *responsesStream) item(added, done map[string]interface{}, body func(itemID string, outputIndex int)) {
+	outputIndex := len(s.output)
+	s.event("response.output_item.added", map[string]interface{}{"output_index": outputIndex, "item": added})
+	body(added["id"].(string), outputIndex)
+	s.event("response.output_item.done", map[string]interface{}{"output_index": outputIndex, "item": done})
+	s.output = append(s.output, done)
+}
+
+func (s *responsesStream) streamResponse() {
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.w.Header().Set("Connection", "keep-alive")
+
+	s.event("response.created", map[string]interface{}{"response": s.response})
+	s.event("response.in_progress", map[string]interface{}{"response": s.response})
+	s.w.(http.Flusher).Flush()
+
+	if s.resp.Thinking != "" {
+		id := util.RandomID("rs_", 32)
+		added := map[string]interface{}{"id": id, "type": "reasoning", "summary": []interface{}{}}
+		s.item(added, reasoningItem(id, s.resp.Thinking), func(itemID string, outputIndex int) {
+			part := func(text string) map[string]interface{} {
+				return map[string]interface{}{"item_id": itemID, "output_index": outputIndex, "summary_index": 0,
+					"part": map[string]string{"type": "summary_text", "text": text}}
+			}
+			s.event("response.reasoning_summary_part.added", part(""))
+			s.deltas("response.reasoning_summary_text.delta", s.resp.split(s.resp.Thinking), s.resp.thinkingPace(), func(ch string) map[string]interface{} {
+				return map[string]interface{}{"item_id": itemID, "output_index": outputIndex, "summary_index": 0, "delta": ch}
+			})
+			s.event("response.reasoning_summary_text.done", map[string]interface{}{
+				"item_id": itemID, "output_index": outputIndex, "summary_index": 0, "text": s.resp.Thinking})
+			s.event("response.reasoning_summary_part.done", part(s.resp.Thinking))
+		})
+	}
+
+	if s.resp.Content != "" {
+		id := util.RandomID("msg_", 32)
+		done := messageItem(id, "completed", []interface{}{outputText(s.resp.Content)})
+		s.item(messageItem(id, "in_progress", []interface{}{}), done, func(itemID string, outputIndex int) {
+			part := func(text string) map[string]interface{} {
+				return map[string]interface{}{"item_id": itemID, "output_index": outputIndex, "content_index": 0, "part": outputText(text)}
+			}
+			s.event("response.content_part.added", part(""))
+			s.deltas("response.output_text.delta", s.resp.split(s.resp.Content), s.resp.contentPace(), func(ch string) map[string]interface{} {
+				return map[string]interface{}{"item_id": itemID, "output_index": outputIndex, "content_index": 0, "delta": ch}
+			})
+			s.event("response.output_text.done", map[string]interface{}{
+				"item_id": itemID, "output_index": outputIndex, "content_index": 0, "text": s.resp.Content})
+			s.event("response.content_part.done", part(s.resp.Content))
+		})
+	}
+
+	for _, call := range s.resp.ToolCalls {
+		id, callID := util.RandomID("fc_", 32), util.RandomID("call_", 24)
+		done := functionCallItem(id, callID, "completed", call, call.Arguments)
+		s.item(functionCallItem(id, callID, "in_progress", call, ""), done, func(itemID string, outputIndex int) {
+			s.deltas("response.function_call_arguments.delta", util.SplitRunes(call.Arguments, toolArgumentsChunkSize), s.resp.toolCallsPace(), func(ch string) map[string]interface{} {
+				return map[string]interface{}{"item_id": itemID, "output_index": outputIndex, "delta": ch}
+			})
+			s.event("response.function_call_arguments.done", map[string]interface{}{
+				"item_id": itemID, "output_index": outputIndex, "arguments": call.Arguments})
+		})
+	}
+
+	s.complete()
+	s.event("response.completed", map[string]interface{}{"response": s.response})
+	s.w.(http.Flusher).Flush()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"mock-stream/recorder"
+)
+
+// Names of the mocked apis, used as keys of Config.Routes
+const (
+	apiChatCompletions  = "chat_completions"
+	apiResponses        = "responses"
+	apiAnthropicMessage = "anthropic_messages"
+	apiGemini           = "gemini"
+	apiOllamaChat       = "ollama_chat"
+	apiOllamaGenerate   = "ollama_generate"
+)
+
+// mockAPIs lists the mocked apis in the order their routes are tried
+var mockAPIs = []string{
+	apiChatCompletions,
+	apiResponses,
+	apiAnthropicMessage,
+	apiGemini,
+	apiOllamaChat,
+	apiOllamaGenerate,
+}
+
+func mockAPIHandler(api string) http.HandlerFunc {
+	switch api {
+	case apiChatCompletions:
+		return handleMockStream
+	case apiResponses:
+		return handleResponses
+	case apiAnthropicMessage:
+		return handleAnthropicMessages
+	case apiGemini:
+		return handleGemini
+	case apiOllamaChat:
+		return handleOllamaChat
+	case apiOllamaGenerate:
+		return handleOllamaGenerate
+	}
+	return handleProxy
+}
+
+// writeAPIError writes an error in the format of the api
+func writeAPIError(w http.ResponseWriter, api string, status int, message string) {
+	switch api {
+	case apiAnthropicMessage:
+		writeAnthropicError(w, status, message)
+	case apiGemini:
+		writeGeminiError(w, status, message)
+	case apiOllamaChat, apiOllamaGenerate:
+		writeOllamaError(w, status, message)
+	default:
+		writeOpenAIError(w, status, message)
+	}
+}
+
+// writeStreamError ends a started stream with an error event in the format of the api
+func writeStreamError(w http.ResponseWriter, api string, status int, message string) {
+	var body map[string]interface{}
+	switch api {
+	case apiAnthropicMessage:
+		jsonData, _ := json.Marshal(anthropicErrorBody(status, message))
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", jsonData)
+		return
+	case apiResponses:
+		details := openAIErrorBody(status, message)["error"].(map[string]interface{})
+		details["type"] = "error"
+		jsonData, _ := json.Marshal(details)
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", jsonData)
+		return
+	case apiGemini:
+		body = geminiErrorBody(status, message)
+	case apiOllamaChat, apiOllamaGenerate:
+		body = ollamaErrorBody(message)
+	default:
+		body = openAIErrorBody(status, message)
+	}
+
+	jsonData, _ := json.Marshal(body)
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		fmt.Fprintf(w, "data: %s\n\n", jsonData)
+	} else {
+		fmt.Fprintf(w, "%s\n", jsonData)
+	}
+}
+
+// defaultRoutes returns the route patterns of every mocked api.
+// "**" matches any number of path segments, "{name}" captures part of a segment.
+// A captured "model" overrides the model of the request body, e.g. the Azure deployment name.
+func defaultRoutes() map[string][]string {
+	return map[string][]string{
+		apiChatCompletions:  {"/openai/deployments/{model}/chat/completions", "**/chat/completions"},
+		apiResponses:        {"/openai/deployments/{model}/responses", "**/responses"},
+		apiAnthropicMessage: {"**/v1/messages"},
+		apiGemini:           {"**/models/{model}:{method}"},
+		apiOllamaChat:       {"**/api/chat"},
+		apiOllamaGenerate:   {"**/api/generate"},
+	}
+}
+
+var (
+	routeRegexps sync.Map // pattern -> *regexp.Regexp
+	paramRegexp  = regexp.MustCompile(`\{(\w+)\}`)
+)
+
+// compileRoute turns a route pattern into an anchored regexp with a named group per parameter
+func compileRoute(pattern string) *regexp.Regexp {
+	if re, ok := routeRegexps.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, segment := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		if segment == "**" {
+			sb.WriteString("(?:/[^/]+)*")
+			continue
+		}
+		sb.WriteString("/")
+		last := 0
+		for _, loc := range paramRegexp.FindAllStringSubmatchIndex(segment, -1) {
+			sb.WriteString(regexp.QuoteMeta(segment[last:loc[0]]))
+			sb.WriteString("(?P<" + segment[loc[2]:loc[3]] + ">[^/]+?)")
+			last = loc[1]
+		}
+		sb.WriteString(regexp.QuoteMeta(segment[last:]))
+	}
+	sb.WriteString("/?$")
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		// Broken patterns never match
+		re = regexp.MustCompile(`^\b$`)
+	}
+	routeRegexps.Store(pattern, re)
+	return re
+}
+
+// matchRoute finds the mocked api serving path and the parameters captured from it
+func matchRoute(path string) (api string, params map[string]string, ok bool) {
+	configMutex.RLock()
+	routes := appConfig.Routes
+	configMutex.RUnlock()
+
+	for _, api := range mockAPIs {
+		for _, pattern := range routes[api] {
+			re := compileRoute(pattern)
+			match := re.FindStringSubmatch(path)
+			if match == nil {
+				continue
+			}
+			params = map[string]string{}
+			for i, name := range re.SubexpNames() {
+				if name != "" {
+					params[name] = match[i]
+				}
+			}
+			return api, params, true
+		}
+	}
+	return "", nil, false
+}
+
+type routeParamsKey struct{}
+
+// routeParam returns a parameter captured by the route of the request
+func routeParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(routeParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+// handleRequest dispatches to the handler of the mocked api matching the path, anything else is proxied.
+// Paths under /__mock/ control the mock server itself.
+func handleRequest(w http.ResponseWriter, r *http.Request) {
+	configMutex.RLock()
+	bodyLimit := appConfig.LogBodyLimit
+	configMutex.RUnlock()
+
+	rec := recorder.NewResponseRecorder(w)
+	rec.CaptureRequestBody(r, bodyLimit)
+	defer requestLogger.Finish(rec)
+	w, r = rec, recorder.WithRecorder(r, rec)
+
+	if r.URL.Path == adminResetSequencesPath {
+		handleResetSequences(w, r)
+		return
+	}
+	api, params, ok := matchRoute(r.URL.Path)
+	if !ok {
+		handleProxy(w, r)
+		return
+	}
+	r = r.WithContext(context.WithValue(r.Context(), routeParamsKey{}, params))
+	if handleReplay(w, r, api) {
+		return
+	}
+	mockAPIHandler(api)(newFaultWriter(w, r, api), r)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompileRoute(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"**/chat/completions", "/chat/completions", true},
+		{"**/chat/completions", "/v1/chat/completions", true},
+		{"**/chat/completions", "/proxy/openai/v1/chat/completions/", true},
+		{"**/chat/completions", "/v1/chat/completions/extra", false},
+		{"**/chat/completions", "/v1/chatxcompletions", false},
+		{"/v1/messages", "/v1/messages", true},
+		{"/v1/messages", "/api/v1/messages", false},
+		{"**/models/{model}:{method}", "/v1beta/models/gemini-pro:generateContent", true},
+		{"**/models/{model}:{method}", "/v1beta/models/gemini-pro", false},
+		{"/openai/deployments/{model}/chat/completions", "/openai/deployments/gpt-4o/chat/completions", true},
+		{"/openai/deployments/{model}/chat/completions", "/openai/deployments/a/b/chat/completions", false},
+	}
+	for _, tt := range tests {
+		if got := compileRoute(tt.pattern).MatchString(tt.path); got != tt.match {
+			t.Errorf("pattern %q on %q matched %v, want %v", tt.pattern, tt.path, got, tt.match)
+		}
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	configMutex.Lock()
+	saved := appConfig.Routes
+	appConfig.Routes = defaultRoutes()
+	configMutex.Unlock()
+	t.Cleanup(func() {
+		configMutex.Lock()
+		appConfig.Routes = saved
+		configMutex.Unlock()
+	})
+
+	tests := []struct {
+		path   string
+		api    string
+		params map[string]string
+	}{
+		{"/v1/chat/completions", apiChatCompletions, map[string]string{}},
+		{"/openai/deployments/my-gpt/chat/completions", apiChatCompletions, map[string]string{"model": "my-gpt"}},
+		{"/openai/deployments/my-gpt/responses", apiResponses, map[string]string{"model": "my-gpt"}},
+		{"/v1/responses", apiResponses, map[string]string{}},
+		{"/anthropic/v1/messages", apiAnthropicMessage, map[string]string{}},
+		{"/v1beta/models/gemini-2.0-flash:streamGenerateContent", apiGemini,
+			map[string]string{"model": "gemini-2.0-flash", "method": "streamGenerateContent"}},
+		{"/api/chat", apiOllamaChat, map[string]string{}},
+		{"/api/generate", apiOllamaGenerate, map[string]string{}},
+		{"/v1/models", "", nil},
+		{"/v1/embeddings", "", nil},
+	}
+	for _, tt := range tests {
+		api, params, ok := matchRoute(tt.path)
+		if ok != (tt.api != "") || api != tt.api || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("matchRoute(%q) = %q, %v, %v, want %q, %v", tt.path, api, params, ok, tt.api, tt.params)
+		}
+	}
+}
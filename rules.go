@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule actions
+const (
+	actionMock  = "mock"
+	actionProxy = "proxy"
+	actionError = "error"
+)
+
+// functionRules builds the rules equivalent to the comma separated MockFunctions,
+// which mock requests by their FunctionName header
+func functionRules(mockFunctions string) []Rule {
+	var rules []Rule
+	for _, mockFunc := range strings.Split(mockFunctions, ",") {
+		mockFunc = strings.TrimSpace(mockFunc)
+		rule := Rule{Name: "function " + mockFunc, Action: actionMock}
+		if mockFunc == "*" {
+			return []Rule{rule}
+		}
+		rule.Match.Headers = map[string]string{"FunctionName": "^" + regexp.QuoteMeta(mockFunc) + "$"}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// resolveMock applies the rules to a request of a mocked api.
+// When the request is to be mocked it returns the answer, otherwise the request has been
+// proxied or answered with an error, and ok is false.
+func resolveMock(w http.ResponseWriter, r *http.Request, req *mockRequest, parseErr error) (resp mockResponse, ok bool) {
+	configMutex.RLock()
+	cfg := appConfig
+	configMutex.RUnlock()
+
+	if !cfg.MockEnabled {
+		handleProxy(w, r)
+		return resp, false
+	}
+
+	rules := cfg.Rules
+	if len(rules) == 0 {
+		rules = functionRules(cfg.MockFunctions)
+	}
+	var rule *Rule
+	var ruleIndex int
+	for i := range rules {
+		if rules[i].Match.matches(r, req) {
+			rule, ruleIndex = &rules[i], i
+			break
+		}
+	}
+	if rule == nil {
+		handleProxy(w, r)
+		return resp, false
+	}
+
+	switch rule.Action {
+	case actionProxy:
+		handleProxyFaults(w, r, rule.Faults)
+		return resp, false
+	case actionError:
+		status, message := mockedError(rule.ErrorStatus, rule.ErrorMessage)
+		requestLogger.LogWithRequest(fmt.Sprintf("Mocking error %d, rule: %s", status, rule.Name), r, message)
+		writeAPIError(w, req.API, status, message)
+		return resp, false
+	case "", actionMock:
+	default:
+		message := fmt.Sprintf("Unknown action %q of rule %s", rule.Action, rule.Name)
+		requestLogger.LogWithRequest(message, r, "")
+		writeAPIError(w, req.API, http.StatusInternalServerError, message)
+		return resp, false
+	}
+
+	if parseErr != nil {
+		requestLogger.LogWithRequest("Invalid request body", r, parseErr.Error())
+		writeAPIError(w, req.API, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", parseErr))
+		return resp, false
+	}
+
+	limit, scope := &cfg.RateLimit, ""
+	if rule.RateLimit != nil {
+		limit, scope = rule.RateLimit, ruleID(rule, ruleIndex)
+	}
+	var bucket string
+	if limit.RequestsPerMinute > 0 || limit.TokensPerMinute > 0 {
+		bucket = rateLimitBucket(limit, scope, ruleID(rule, ruleIndex), r)
+		state := takeRateLimit(limit, bucket, promptTokens(req.Messages))
+		setRateLimitHeaders(w, req.API, state)
+		if state.retryAfter > 0 {
+			message := rateLimitMessage(state)
+			requestLogger.LogWithRequest(fmt.Sprintf("Rate limited, rule: %s", rule.Name), r, message)
+			writeAPIError(w, req.API, http.StatusTooManyRequests, message)
+			return resp, false
+		}
+	}
+
+	summary := fmt.Sprintf("Mocking function: %s, rule: %s", r.Header.Get("FunctionName"), rule.Name)
+	if fault := pickFault(rule.Faults, req.Stream); fault != nil {
+		if !slices.Contains(faultTypes, fault.Type) {
+			message := fmt.Sprintf("Unknown fault %q of rule %s", fault.Type, rule.Name)
+			requestLogger.LogWithRequest(message, r, "")
+			writeAPIError(w, req.API, http.StatusInternalServerError, message)
+			return resp, false
+		}
+		if fault.Type == faultHTTPError {
+			status, message := mockedError(fault.Status, fault.Message)
+			requestLogger.LogWithRequest(fmt.Sprintf("Mocking error %d, rule: %s, fault: %s", status, rule.Name, fault.Type), r, message)
+			writeAPIError(w, req.API, status, message)
+			return resp, false
+		}
+		if fw, ok := w.(*faultWriter); ok {
+			fw.arm(fault, req.Stream)
+		}
+		summary += ", fault: " + fault.Type
+	}
+	answer := configAnswer(&cfg)
+	profileName := rule.Profile
+	if len(rule.Sequence) > 0 {
+		step := nextSequenceStep(rule, sequenceKey(rule, ruleIndex, r))
+		profileName = rule.Sequence[step]
+		summary += fmt.Sprintf(", step %d/%d", step+1, len(rule.Sequence))
+	}
+	// A sequence wins over the response and the profile of the rule
+	switch {
+	case len(rule.Sequence) == 0 && rule.Response != nil:
+		answer = *rule.Response
+	case profileName != "":
+		profile, ok := findProfile(cfg.Profiles, profileName)
+		if !ok {
+			message := fmt.Sprintf("Profile %q of rule %s not found", profileName, rule.Name)
+			requestLogger.LogWithRequest(message, r, "")
+			writeAPIError(w, req.API, http.StatusInternalServerError, message)
+			return resp, false
+		}
+		answer = profile.MockAnswer
+		summary += ", profile: " + profileName
+	}
+	resp, err := answer.response(&cfg)
+	if err != nil {
+		summary += fmt.Sprintf(", chunking error: %v", err)
+	}
+	if err := resp.render(newTemplateData(r, req)); err != nil {
+		summary += fmt.Sprintf(", template error: %v", err)
+	}
+	if answer.Echo {
+		// After rendering, so the echoed message is never taken as a template
+		resp.Content = echoText(req.lastUserMessage(), answer.EchoTransform)
+		summary += ", echo"
+	}
+	// The completion counts against the budget once it is known
+	if bucket != "" {
+		setRateLimitHeaders(w, req.API, chargeRateLimit(limit, bucket, resp.completionTokens()))
+	}
+
+	requestLogger.LogWithRequest(summary, r, fmt.Sprintf("Thinking: %s\nContent: %s\nToolCalls: %v\nRawMode: %t\nStream: %t",
+		resp.Thinking, resp.Content, resp.ToolCalls, resp.RawMode, req.Stream))
+
+	// The latency model delays the headers, answers sent at once also by the time streaming would take
+	if resp.timer != nil {
+		time.Sleep(resp.timer.TTFB())
+		if !req.Stream {
+			time.Sleep(resp.generationTime())
+		}
+	}
+	return resp, true
+}
+
+func (m *RuleMatch) matches(r *http.Request, req *mockRequest) bool {
+	if m.Method != "" && !strings.EqualFold(m.Method, r.Method) {
+		return false
+	}
+	if m.API != "" && m.API != req.API {
+		return false
+	}
+	if !matchRegexp(m.Path, r.URL.Path) || !matchRegexp(m.Model, req.Model) {
+		return false
+	}
+	for name, expr := range m.Headers {
+		if !matchRegexp(expr, r.Header.Get(name)) {
+			return false
+		}
+	}
+	query := r.URL.Query()
+	for name, expr := range m.Query {
+		if !matchRegexp(expr, query.Get(name)) {
+			return false
+		}
+	}
+	for path, expr := range m.Body {
+		value, ok := jsonField(req.decodedBody(), path)
+		if !ok || !matchRegexp(expr, value) {
+			return false
+		}
+	}
+	if m.LastUserMessage != "" && !matchRegexp(m.LastUserMessage, req.lastUserMessage()) {
+		return false
+	}
+	if m.HasTools != nil && *m.HasTools != (len(req.toolNames()) > 0) {
+		return false
+	}
+	return true
+}
+
+var ruleRegexps sync.Map // expression -> *regexp.Regexp, nil when invalid
+
+// matchRegexp reports whether s matches expr. An empty expression matches everything, an invalid one nothing.
+func matchRegexp(expr, s string) bool {
+	if expr == "" {
+		return true
+	}
+	cached, ok := ruleRegexps.Load(expr)
+	if !ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			requestLogger.LogWithRequest(fmt.Sprintf("Invalid rule expression %q: %v", expr, err), nil, "")
+			re = nil
+		}
+		cached, _ = ruleRegexps.LoadOrStore(expr, re)
+	}
+	re := cached.(*regexp.Regexp)
+	return re != nil && re.MatchString(s)
+}
+
+// jsonField looks up a dot separated path in decoded JSON. Array indices may be negative to count from the end.
+// Values other than strings are returned JSON encoded.
+func jsonField(v interface{}, path string) (string, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[key]; !ok {
+				return "", false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if i < 0 {
+				i += len(node)
+			}
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			v = node[i]
+		default:
+			return "", false
+		}
+	}
+	if s, ok := v.(string); ok {
+		return s, true
+	}
+	data, _ := json.Marshal(v)
+	return string(data), true
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mock-stream/recorder"
+)
+
+func TestJSONField(t *testing.T) {
+	var body interface{}
+	err := json.Unmarshal([]byte(`{
+		"model": "gpt-4o",
+		"stream": true,
+		"temperature": 0.5,
+		"messages": [
+			{"role": "system", "content": "Be brief"},
+			{"role": "user", "content": "Hello"}
+		],
+		"metadata": {"user": {"id": "u1"}, "tags": []},
+		"tool_choice": null
+	}`), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		value string
+		ok    bool
+	}{
+		{"model", "gpt-4o", true},
+		{"stream", "true", true},
+		{"temperature", "0.5", true},
+		{"messages.0.role", "system", true},
+		{"messages.-1.content", "Hello", true},
+		{"messages.-2.content", "Be brief", true},
+		{"messages.1", `{"content":"Hello","role":"user"}`, true},
+		{"metadata.user.id", "u1", true},
+		{"metadata.tags", "[]", true},
+		{"tool_choice", "null", true},
+		{"messages.2", "", false},
+		{"messages.-3", "", false},
+		{"messages.first", "", false},
+		{"model.name", "", false},
+		{"missing", "", false},
+		{"metadata.user.name", "", false},
+	}
+	for _, tt := range tests {
+		value, ok := jsonField(body, tt.path)
+		if value != tt.value || ok != tt.ok {
+			t.Errorf("jsonField(%q) = %q, %v, want %q, %v", tt.path, value, ok, tt.value, tt.ok)
+		}
+	}
+}
+
+// useConfig runs the test with cfg as the config of the server and a fresh request log
+func useConfig(t *testing.T, cfg Config) {
+	t.Helper()
+	configMutex.Lock()
+	saved, savedLogger := appConfig, requestLogger
+	appConfig, requestLogger = cfg, recorder.NewRequestLogger(100)
+	configMutex.Unlock()
+	t.Cleanup(func() {
+		configMutex.Lock()
+		appConfig, requestLogger = saved, savedLogger
+		configMutex.Unlock()
+	})
+}
+
+// testConfig is the default config without the waits between chunks, and without a backend
+func testConfig() Config {
+	cfg := defaultConfig()
+	cfg.BackendURL = ""
+	cfg.MockContent = "Default answer"
+	cfg.MockThinking = ""
+	cfg.MockContentRate, cfg.MockThinkingRate, cfg.MockToolCallsRate = 0, 0, 0
+	return cfg
+}
+
+// serve sends a request through the server handler and returns the response
+func serve(method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for name, value := range header {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	handleRequest(w, r)
+	return w
+}
+
+const chatBody = `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+
+func TestRuleMatch(t *testing.T) {
+	useConfig(t, testConfig())
+	yes, no := true, false
+	r := httptest.NewRequest("POST", "/v1beta/models/gemini-pro:streamGenerateContent?alt=sse", nil)
+	r.Header.Set("FunctionName", "search")
+	body := `{"tools":[{"functionDeclarations":[{"name":"lookup"}]}],"contents":[{"role":"user"}]}`
+	req := &mockRequest{
+		API:      apiGemini,
+		Model:    "gemini-pro",
+		Messages: []chatMessage{textMessage("user", "What is the weather?"), textMessage("assistant", "Sunny")},
+		Body:     []byte(body),
+	}
+
+	tests := []struct {
+		name  string
+		match RuleMatch
+		want  bool
+	}{
+		{"empty", RuleMatch{}, true},
+		{"method in any case", RuleMatch{Method: "post"}, true},
+		{"other method", RuleMatch{Method: "GET"}, false},
+		{"api", RuleMatch{API: apiGemini}, true},
+		{"other api", RuleMatch{API: apiChatCompletions}, false},
+		{"path", RuleMatch{Path: `:streamGenerateContent$`}, true},
+		{"other path", RuleMatch{Path: `^/v1/chat`}, false},
+		{"model", RuleMatch{Model: `^gemini-`}, true},
+		{"other model", RuleMatch{Model: `^gpt-`}, false},
+		{"header", RuleMatch{Headers: map[string]string{"FunctionName": `^search$`}}, true},
+		{"missing header", RuleMatch{Headers: map[string]string{"X-Session-Id": `.+`}}, false},
+		{"query", RuleMatch{Query: map[string]string{"alt": `^sse$`}}, true},
+		{"other query", RuleMatch{Query: map[string]string{"alt": `^json$`}}, false},
+		{"body field", RuleMatch{Body: map[string]string{"contents.-1.role": `^user$`}}, true},
+		{"missing body field", RuleMatch{Body: map[string]string{"system": `.*`}}, false},
+		{"last user message", RuleMatch{LastUserMessage: `(?i)weather`}, true},
+		{"other last user message", RuleMatch{LastUserMessage: `^Sunny$`}, false},
+		{"has tools", RuleMatch{HasTools: &yes}, true},
+		{"has no tools", RuleMatch{HasTools: &no}, false},
+		{"invalid expression", RuleMatch{Path: `(`}, false},
+		{"all conditions", RuleMatch{Method: "POST", API: apiGemini, Model: "pro", LastUserMessage: "weather", HasTools: &yes}, true},
+		{"one condition fails", RuleMatch{Method: "POST", API: apiGemini, Model: "flash"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.match.matches(r, req); got != tt.want {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveMock(t *testing.T) {
+	caseRule := func(name string) RuleMatch {
+		return RuleMatch{Headers: map[string]string{"X-Case": "^" + name + "$"}}
+	}
+	cfg := testConfig()
+	cfg.Profiles = []Profile{{Name: "p1", MockAnswer: MockAnswer{Content: "Profile answer"}}}
+	cfg.Rules = []Rule{
+		{Name: "error", Match: caseRule("error"), Action: actionError, ErrorStatus: 418, ErrorMessage: "Mocked teapot"},
+		{Name: "error defaults", Match: caseRule("error defaults"), Action: actionError},
+		{Name: "proxy", Match: caseRule("proxy"), Action: actionProxy},
+		{Name: "unknown", Match: caseRule("unknown"), Action: "drop"},
+		{Name: "inline", Match: caseRule("inline"), Response: &MockAnswer{Content: "Inline answer"}},
+		{Name: "profile", Match: caseRule("profile"), Profile: "p1"},
+		{Name: "missing profile", Match: caseRule("missing profile"), Profile: "p2"},
+		{Name: "default", Match: caseRule("default")},
+		{Name: "first wins", Match: caseRule("default"), Response: &MockAnswer{Content: "Never sent"}},
+	}
+	useConfig(t, cfg)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string // part of the response body
+	}{
+		{"error", chatBody, 418, "Mocked teapot"},
+		{"error defaults", chatBody, 500, "Mocked error 500 Internal Server Error"},
+		{"proxy", chatBody, 502, "Proxy URL is not set"},
+		{"unknown", chatBody, 500, `Unknown action \"drop\" of rule unknown`},
+		{"inline", chatBody, 200, "Inline answer"},
+		{"profile", chatBody, 200, "Profile answer"},
+		{"missing profile", chatBody, 500, `Profile \"p2\" of rule missing profile not found`},
+		{"default", chatBody, 200, "Default answer"},
+		{"default", `{"model":`, 400, "Invalid JSON body"},
+		{"", chatBody, 502, "Proxy URL is not set"},
+	}
+	for _, tt := range tests {
+		w := serve("POST", "/v1/chat/completions", tt.body, map[string]string{"X-Case": tt.name})
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("case %q: got %d %s, want %d with %q", tt.name, w.Code, w.Body, tt.status, tt.want)
+		}
+	}
+}
+
+func TestResolveMockWithoutRules(t *testing.T) {
+	tests := []struct {
+		name          string
+		mockEnabled   bool
+		mockFunctions string
+		function      string
+		mocked        bool
+	}{
+		{"any function", true, "*", "search", true},
+		{"listed function", true, "search, lookup", "lookup", true},
+		{"unlisted function", true, "search, lookup", "other", false},
+		{"partial name", true, "search", "search2", false},
+		{"mocking disabled", false, "*", "search", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.MockEnabled, cfg.MockFunctions = tt.mockEnabled, tt.mockFunctions
+			useConfig(t, cfg)
+			w := serve("POST", "/v1/chat/completions", chatBody, map[string]string{"FunctionName": tt.function})
+			if mocked := strings.Contains(w.Body.String(), "Default answer"); mocked != tt.mocked {
+				t.Errorf("mocked %v, want %v: %d %s", mocked, tt.mocked, w.Code, w.Body)
+			}
+		})
+	}
+}
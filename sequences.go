@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Sequence modes
+const (
+	sequenceStick = "stick" // stay on the last profile once reached
+	sequenceCycle = "cycle" // start over after the last profile
+)
+
+// adminResetSequencesPath resets all sequence positions when POSTed to
+const adminResetSequencesPath = "/__mock/sequences/reset"
+
+var (
+	sequenceMutex     sync.Mutex
+	sequencePositions = map[string]int{}
+)
+
+// ruleID identifies a rule by its name, or its position when it has none
+func ruleID(rule *Rule, index int) string {
+	if rule.Name == "" {
+		return "#" + strconv.Itoa(index)
+	}
+	return rule.Name
+}
+
+// sequenceKey identifies the position of a rule, per session when the rule has a session header
+func sequenceKey(rule *Rule, index int, r *http.Request) string {
+	key := ruleID(rule, index)
+	if rule.SessionHeader != "" {
+		key += "\x00" + r.Header.Get(rule.SessionHeader)
+	}
+	return key
+}
+
+// nextSequenceStep returns the step of the sequence to answer with and advances the position
+func nextSequenceStep(rule *Rule, key string) int {
+	sequenceMutex.Lock()
+	defer sequenceMutex.Unlock()
+
+	step := sequencePositions[key]
+	if step >= len(rule.Sequence) {
+		if rule.SequenceMode == sequenceCycle {
+			step = 0
+		} else {
+			step = len(rule.Sequence) - 1
+		}
+	}
+	sequencePositions[key] = step + 1
+	return step
+}
+
+// resetSequences starts every sequence from its first profile again
+func resetSequences() {
+	sequenceMutex.Lock()
+	defer sequenceMutex.Unlock()
+	sequencePositions = map[string]int{}
+}
+
+func handleResetSequences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	resetSequences()
+	requestLogger.LogWithRequest("Sequences reset", r, "")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNextSequenceStep(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  Rule
+		steps []int
+	}{
+		{"stick by default", Rule{Sequence: []string{"a", "b", "c"}}, []int{0, 1, 2, 2, 2}},
+		{"stick", Rule{Sequence: []string{"a", "b"}, SequenceMode: sequenceStick}, []int{0, 1, 1, 1}},
+		{"cycle", Rule{Sequence: []string{"a", "b", "c"}, SequenceMode: sequenceCycle}, []int{0, 1, 2, 0, 1, 2, 0}},
+		{"single profile", Rule{Sequence: []string{"a"}, SequenceMode: sequenceCycle}, []int{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetSequences()
+			t.Cleanup(resetSequences)
+			var steps []int
+			for range tt.steps {
+				steps = append(steps, nextSequenceStep(&tt.rule, "key"))
+			}
+			if !reflect.DeepEqual(steps, tt.steps) {
+				t.Errorf("steps = %v, want %v", steps, tt.steps)
+			}
+		})
+	}
+}
+
+func TestSequenceKey(t *testing.T) {
+	r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	r.Header.Set("X-Session-Id", "s1")
+	tests := []struct {
+		name string
+		rule Rule
+		want string
+	}{
+		{"named", Rule{Name: "flaky"}, "flaky"},
+		{"unnamed", Rule{}, "#3"},
+		{"session", Rule{Name: "flaky", SessionHeader: "X-Session-Id"}, "flaky\x00s1"},
+		{"missing session", Rule{Name: "flaky", SessionHeader: "X-User"}, "flaky\x00"},
+	}
+	for _, tt := range tests {
+		if got := sequenceKey(&tt.rule, 3, r); got != tt.want {
+			t.Errorf("%s: key = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSequenceRule(t *testing.T) {
+	cfg := testConfig()
+	cfg.Profiles = []Profile{
+		{Name: "first", MockAnswer: MockAnswer{Content: "First answer"}},
+		{Name: "second", MockAnswer: MockAnswer{Content: "Second answer"}},
+	}
+	cfg.Rules = []Rule{{
+		Name:          "sequence",
+		Sequence:      []string{"first", "second"},
+		SessionHeader: "X-Session-Id",
+		// The sequence wins over the response
+		Response: &MockAnswer{Content: "Inline answer"},
+	}}
+	useConfig(t, cfg)
+	resetSequences()
+	t.Cleanup(resetSequences)
+
+	steps := []struct {
+		session string
+		want    string
+	}{
+		{"a", "First answer"},
+		{"a", "Second answer"},
+		{"b", "First answer"},
+		{"a", "Second answer"},
+		{"reset", ""},
+		{"a", "First answer"},
+		{"b", "First answer"},
+	}
+	for i, step := range steps {
+		if step.session == "reset" {
+			if w := serve("GET", adminResetSequencesPath, "", nil); w.Code != 405 {
+				t.Errorf("GET reset: status %d, want 405", w.Code)
+			}
+			if w := serve("POST", adminResetSequencesPath, "", nil); w.Code != 204 {
+				t.Errorf("POST reset: status %d, want 204", w.Code)
+			}
+			continue
+		}
+		w := serve("POST", "/v1/chat/completions", chatBody, map[string]string{"X-Session-Id": step.session})
+		if !strings.Contains(w.Body.String(), step.want) {
+			t.Errorf("request %d of session %s: got %s, want %q", i+1, step.session, w.Body, step.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// templateData is what the templates of a mock answer can refer to
+type templateData struct {
+	Model           string
+	Messages        []templateMessage
+	LastUserMessage string
+	Headers         http.Header // e.g. {{.Headers.Get "X-Session-Id"}}
+	ToolNames       []string
+}
+
+type templateMessage struct {
+	Role string
+	Text string
+}
+
+var (
+	counterMutex sync.Mutex
+	counters     = map[string]int{}
+)
+
+var templateFuncs = template.FuncMap{
+	// randomChoice returns one of its arguments
+	"randomChoice": func(choices ...string) string {
+		if len(choices) == 0 {
+			return ""
+		}
+		n, _ := rand.Int(rand.Reader, big.NewInt(int64(len(choices))))
+		return choices[n.Int64()]
+	},
+	// uuid returns a random version 4 UUID
+	"uuid": func() string {
+		b := make([]byte, 16)
+		rand.Read(b)
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	},
+	"now": time.Now,
+	// counter counts the renders using the named counter, starting at 1
+	"counter": func(name string) int {
+		counterMutex.Lock()
+		defer counterMutex.Unlock()
+		counters[name]++
+		return counters[name]
+	},
+	// json encodes a value, e.g. to quote text inside tool call arguments
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join": strings.Join,
+}
+
+func newTemplateData(r *http.Request, req *mockRequest) templateData {
+	messages := make([]templateMessage, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		messages = append(messages, templateMessage{Role: m.Role, Text: m.Text()})
+	}
+	return templateData{
+		Model:           req.Model,
+		Messages:        messages,
+		LastUserMessage: req.lastUserMessage(),
+		Headers:         r.Header,
+		ToolNames:       req.toolNames(),
+	}
+}
+
+// renderTemplate executes text as a template, text without actions is returned as is
+func renderTemplate(text string, data templateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New("").Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return text, err
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return text, err
+	}
+	return sb.String(), nil
+}
+
+// render executes the templates in the thinking, content and tool call arguments.
+// Texts failing to render are kept unchanged, the first error is returned.
+func (resp *mockResponse) render(data templateData) error {
+	var firstErr error
+	render := func(text string) string {
+		rendered, err := renderTemplate(text, data)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		return rendered
+	}
+
+	resp.Thinking = render(resp.Thinking)
+	resp.Content = render(resp.Content)
+	// The calls may be shared with the config, render into a copy
+	toolCalls := make([]ToolCall, len(resp.ToolCalls))
+	for i, call := range resp.ToolCalls {
+		toolCalls[i] = ToolCall{Name: call.Name, Arguments: render(call.Arguments)}
+	}
+	resp.ToolCalls = toolCalls
+	return firstErr
+}
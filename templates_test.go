@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testTemplateData() templateData {
+	r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	r.Header.Set("X-Session-Id", "s1")
+	return newTemplateData(r, &mockRequest{
+		Model:    "gpt-4o",
+		Messages: []chatMessage{textMessage("system", "Be brief"), textMessage("user", `Say "hi"`)},
+		Body:     []byte(`{"tools":[{"type":"function","function":{"name":"search"}},{"type":"function","function":{"name":"lookup"}}]}`),
+	})
+}
+
+func TestRenderTemplate(t *testing.T) {
+	counterMutex.Lock()
+	counters = map[string]int{}
+	counterMutex.Unlock()
+
+	tests := []struct {
+		name string
+		text string
+		want string // a regular expression for random output
+		err  bool
+	}{
+		{"no actions", "Hello {name}", `^Hello \{name\}$`, false},
+		{"model", "Model {{.Model}}", `^Model gpt-4o$`, false},
+		{"last user message", "You said: {{.LastUserMessage}}", `^You said: Say "hi"$`, false},
+		{"messages", `{{len .Messages}} {{(index .Messages 0).Role}}: {{(index .Messages 0).Text}}`, `^2 system: Be brief$`, false},
+		{"header", `{{.Headers.Get "X-Session-Id"}}`, `^s1$`, false},
+		{"tool names", `{{join .ToolNames ", "}}`, `^search, lookup$`, false},
+		{"json", `{"text": {{json .LastUserMessage}}}`, `^\{"text": "Say \\"hi\\""\}$`, false},
+		{"random choice", `{{randomChoice "a" "b"}}`, `^[ab]$`, false},
+		{"random choice of nothing", `{{randomChoice}}`, `^$`, false},
+		{"uuid", `{{uuid}}`, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, false},
+		{"now", `{{now.Year}}`, "^" + strconv.Itoa(time.Now().Year()) + "$", false},
+		{"counter", `{{counter "a"}} {{counter "a"}} {{counter "b"}}`, `^1 2 1$`, false},
+		{"counter continues", `{{counter "a"}}`, `^3$`, false},
+		{"parse error is kept", "Hi {{.Model", `^Hi \{\{\.Model$`, true},
+		{"unknown field is kept", "Hi {{.Name}}", `^Hi \{\{\.Name\}\}$`, true},
+	}
+	data := testTemplateData()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderTemplate(tt.text, data)
+			if (err != nil) != tt.err {
+				t.Errorf("error = %v, want error %v", err, tt.err)
+			}
+			if !regexp.MustCompile(tt.want).MatchString(got) {
+				t.Errorf("rendered %q, want a match of %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderResponse(t *testing.T) {
+	calls := []ToolCall{{Name: "search", Arguments: `{"q": {{json .LastUserMessage}}}`}}
+	resp := mockResponse{
+		Thinking:  "Thinking about {{.Model}}",
+		Content:   "Hi {{.Model",
+		ToolCalls: calls,
+	}
+	if err := resp.render(testTemplateData()); err == nil {
+		t.Error("render succeeded, want the error of the content")
+	}
+	if resp.Thinking != "Thinking about gpt-4o" {
+		t.Errorf("thinking = %q", resp.Thinking)
+	}
+	if resp.Content != "Hi {{.Model" {
+		t.Errorf("content = %q, want it unchanged", resp.Content)
+	}
+	if got := resp.ToolCalls[0].Arguments; got != `{"q": "Say \"hi\""}` {
+		t.Errorf("arguments = %q", got)
+	}
+	if calls[0].Arguments != `{"q": {{json .LastUserMessage}}}` {
+		t.Errorf("the tool calls of the config were changed to %q", calls[0].Arguments)
+	}
+}
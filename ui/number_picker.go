@@ -19,6 +19,7 @@ type NumberPicker struct {
 	maxVal     int
 	name       string
 	gui        fyne.CanvasObject
+	onChanged  func(int)
 }
 
 // NewPortPicker creates a NumberPicker specifically for port selection (1-65535)
@@ -74,6 +75,9 @@ func NewNumberPicker(name string, initialVal, minVal, maxVal int, withUpDown boo
 	p.entry.OnChanged = func(s string) {
 		if val, err := strconv.Atoi(s); err == nil {
 			p.current = val
+			if p.onChanged != nil {
+				p.onChanged(p.GetValue())
+			}
 		}
 	}
 
@@ -87,6 +91,16 @@ func (p *NumberPicker) GetValue() int {
 	return p.current
 }
 
+// SetValue updates the picker as if the value was typed in
+func (p *NumberPicker) SetValue(val int) {
+	p.entry.SetText(strconv.Itoa(val))
+}
+
+// SetOnChanged registers a callback for every valid number entered
+func (p *NumberPicker) SetOnChanged(f func(int)) {
+	p.onChanged = f
+}
+
 func (p *NumberPicker) GetUI() fyne.CanvasObject {
 	if p.gui == nil {
 		// Create a horizontal layout for the buttons
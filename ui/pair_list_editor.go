@@ -0,0 +1,115 @@
+package ui
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Pair is one row of a PairListEditor
+type Pair struct {
+	Key   string
+	Value string
+}
+
+// PairListEditor edits a list of key/value rows, e.g. tool call names and their arguments
+type PairListEditor struct {
+	keyHint   string
+	valueHint string
+	rows      []*pairRow
+	box       *fyne.Container
+	gui       fyne.CanvasObject
+	onChanged func([]Pair)
+}
+
+type pairRow struct {
+	key   *widget.Entry
+	value *widget.Entry
+	ui    fyne.CanvasObject
+}
+
+func NewPairListEditor(keyHint, valueHint string) *PairListEditor {
+	return &PairListEditor{
+		keyHint:   keyHint,
+		valueHint: valueHint,
+		box:       container.NewVBox(),
+	}
+}
+
+// SetOnChanged registers a callback receiving all rows after any edit
+func (e *PairListEditor) SetOnChanged(f func([]Pair)) {
+	e.onChanged = f
+}
+
+// AddButton returns a button appending an empty row
+func (e *PairListEditor) AddButton(label string) *widget.Button {
+	return widget.NewButton(label, func() {
+		e.addRow(Pair{})
+		e.changed()
+	})
+}
+
+// SetPairs replaces all rows
+func (e *PairListEditor) SetPairs(pairs []Pair) {
+	e.rows = nil
+	e.box.RemoveAll()
+	for _, p := range pairs {
+		e.addRow(p)
+	}
+	e.changed()
+}
+
+func (e *PairListEditor) GetPairs() []Pair {
+	pairs := make([]Pair, 0, len(e.rows))
+	for _, row := range e.rows {
+		pairs = append(pairs, Pair{Key: row.key.Text, Value: row.value.Text})
+	}
+	return pairs
+}
+
+func (e *PairListEditor) GetUI() fyne.CanvasObject {
+	if e.gui == nil {
+		e.gui = e.box
+	}
+	return e.gui
+}
+
+func (e *PairListEditor) addRow(p Pair) {
+	row := &pairRow{
+		key:   widget.NewEntry(),
+		value: widget.NewMultiLineEntry(),
+	}
+	row.key.SetPlaceHolder(e.keyHint)
+	row.key.SetText(p.Key)
+	row.value.SetPlaceHolder(e.valueHint)
+	row.value.SetText(p.Value)
+	row.value.SetMinRowsVisible(2)
+	row.key.OnChanged = func(string) { e.changed() }
+	row.value.OnChanged = func(string) { e.changed() }
+
+	removeButton := widget.NewButton("✕", func() {
+		e.removeRow(row)
+		e.changed()
+	})
+	removeButton.Importance = widget.LowImportance
+
+	row.ui = container.NewBorder(row.key, nil, nil, removeButton, row.value)
+	e.rows = append(e.rows, row)
+	e.box.Add(row.ui)
+}
+
+func (e *PairListEditor) removeRow(row *pairRow) {
+	for i, r := range e.rows {
+		if r == row {
+			e.rows = append(e.rows[:i], e.rows[i+1:]...)
+			break
+		}
+	}
+	e.box.Remove(row.ui)
+}
+
+func (e *PairListEditor) changed() {
+	if e.onChanged != nil {
+		e.onChanged(e.GetPairs())
+	}
+}
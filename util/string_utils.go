@@ -1,5 +1,11 @@
 package util
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"unicode/utf8"
+)
+
 // InsertStringConcat insert string at position
 func InsertStringConcat(text string, n int, s string) string {
 	runes := []rune(text)
@@ -12,3 +18,29 @@ func InsertStringConcat(text string, n int, s string) string {
 	copy(result[n+len([]rune(s)):], runes[n:])
 	return string(result)
 }
+
+// RandomID returns prefix followed by n random hex characters, e.g. "chatcmpl-3f9a..."
+func RandomID(prefix string, n int) string {
+	b := make([]byte, (n+1)/2)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)[:n]
+}
+
+// EstimateTokens approximates the token count of text, about 4 characters per token
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// SplitRunes splits text into pieces of at most n runes
+func SplitRunes(text string, n int) []string {
+	runes := []rune(text)
+	var pieces []string
+	for len(runes) > n {
+		pieces = append(pieces, string(runes[:n]))
+		runes = runes[n:]
+	}
+	if len(runes) > 0 {
+		pieces = append(pieces, string(runes))
+	}
+	return pieces
+}